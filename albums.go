@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	fpath "path/filepath"
+	"strings"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// album is a folder of flac files that the commands work on,
+// like "1990/World Violation Tour/Leg 1/1990-05-02 Somewhere"
+type album struct {
+	path string // the full path to the album
+	name string // the folder name, which its .txt, .ffp and .md5 files are named after
+	rel  string // the path from the directory given, to show the user
+}
+
+// findAlbums returns the album given with --single, or every album found
+// at any depth below the directory given, that passes the filter flags.
+// Albums that can't be looked at are explained on w.
+func findAlbums(c *cli.Context, filepath string, fileInfo os.FileInfo, w io.Writer) ([]album, error) {
+	filter, err := newAlbumFilter(c)
+	if err != nil {
+		return nil, newUsageError(c, "%s", err.Error())
+	}
+
+	var found []album
+	if c.GlobalBool("single") {
+		found = []album{{filepath, fileInfo.Name(), fileInfo.Name()}}
+	} else {
+		files, err := ioutil.ReadDir(filepath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			found = albumsIn(found, filepath, fpath.Join(filepath, file.Name()), file, w)
+		}
+	}
+
+	var albums []album
+	for _, album := range found {
+		if filter.allows(album, w) {
+			albums = append(albums, album)
+		}
+	}
+	if len(albums) < len(found) {
+		fmt.Fprintf(w, "%d of %d albums left out by --include, --exclude, --since or --changed-since\n", len(found)-len(albums), len(found))
+	}
+	return albums, nil
+}
+
+// albumsIn adds the folder to albums if it is an album,
+// otherwise it looks for albums in the folders inside it
+func albumsIn(albums []album, root string, directory string, file os.FileInfo, w io.Writer) []album {
+	if !file.IsDir() || albumSkipFolder(file.Name()) {
+		return albums
+	}
+
+	contents, err := ioutil.ReadDir(directory)
+	if err != nil {
+		fmt.Fprintf(w, "Could not look for albums in %s (%s)\n", directory, err.Error())
+		return albums
+	}
+
+	if isAlbum(directory, contents) {
+		rel, err := fpath.Rel(root, directory)
+		if err != nil {
+			rel = directory
+		}
+		return append(albums, album{directory, file.Name(), rel})
+	}
+
+	for _, file := range contents {
+		albums = albumsIn(albums, root, fpath.Join(directory, file.Name()), file, w)
+	}
+	return albums
+}
+
+// An album is a folder with flac files or CD folders in it,
+// or one that already has an information file
+func isAlbum(directory string, contents []os.FileInfo) bool {
+	for _, file := range contents {
+		name := file.Name()
+		if file.IsDir() {
+			if isCDFolder(name) {
+				return true
+			}
+		} else if fpath.Ext(name) == ".flac" || name == fpath.Base(directory)+".txt" {
+			return true
+		}
+	}
+	return false
+}
+
+// isCDFolder reports whether the name is like "CD1"
+func isCDFolder(name string) bool {
+	number := strings.TrimPrefix(name, "CD")
+	if number == name || number == "" {
+		return false
+	}
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// The wiki files, and hidden folders like ".git", are never albums
+func albumSkipFolder(name string) bool {
+	return name == "__wikifiles" || strings.HasPrefix(name, ".")
+}
+
+func printAlbumCount(w io.Writer, albums []album) {
+	if len(albums) == 1 {
+		fmt.Fprintln(w, "Found 1 album")
+	} else {
+		fmt.Fprintf(w, "Found %d albums\n", len(albums))
+	}
+}
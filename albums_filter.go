@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	fpath "path/filepath"
+	"strings"
+	"time"
+
+	"github.com/qaisjp/dmlivewiki/util"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Every batch command can be narrowed down to some of its albums with these
+var albumFilterFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "include",
+		Usage: "only process albums whose folder name or path matches this glob (can be given more than once)",
+	},
+	cli.StringSliceFlag{
+		Name:  "exclude",
+		Usage: "skip albums whose folder name or path matches this glob (can be given more than once)",
+	},
+	cli.StringFlag{
+		Name:  "since",
+		Usage: "only process albums whose date tag is on or after this date, like 1990-05-02",
+	},
+	cli.StringFlag{
+		Name:  "changed-since",
+		Usage: "only process albums with files modified since this time, like 2024-01-31, \"2024-01-31 18:00\" or 48h (ago)",
+	},
+}
+
+// albumFilter decides which albums found in batch mode are processed
+type albumFilter struct {
+	include      []string
+	exclude      []string
+	since        time.Time // zero if --since wasn't given
+	changedSince time.Time // zero if --changed-since wasn't given
+}
+
+func newAlbumFilter(c *cli.Context) (*albumFilter, error) {
+	f := &albumFilter{
+		include: c.StringSlice("include"),
+		exclude: c.StringSlice("exclude"),
+	}
+
+	for _, pattern := range append(f.include, f.exclude...) {
+		if _, err := fpath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q (%s)", pattern, err.Error())
+		}
+	}
+
+	if since := c.String("since"); since != "" {
+		day, err := util.ParseDate(since)
+		if err != nil {
+			return nil, fmt.Errorf("--since: %s", err.Error())
+		}
+		f.since = day
+	}
+
+	if changed := c.String("changed-since"); changed != "" {
+		t, err := parseChangedSince(changed)
+		if err != nil {
+			return nil, fmt.Errorf("--changed-since: %s", err.Error())
+		}
+		f.changedSince = t
+	}
+	return f, nil
+}
+
+// Times are in local time, because that's what file times are shown in
+var changedSinceLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// parseChangedSince reads a time, or a duration to go back from now
+func parseChangedSince(str string) (time.Time, error) {
+	str = strings.TrimSpace(str)
+	if d, err := time.ParseDuration(str); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	for _, layout := range changedSinceLayouts {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not understand time %q", str)
+}
+
+// allows reports whether an album should be processed,
+// explaining on w if it can't be worked out
+func (f *albumFilter) allows(a album, w io.Writer) bool {
+	if len(f.include) > 0 && !albumMatches(a, f.include) {
+		return false
+	}
+	if albumMatches(a, f.exclude) {
+		return false
+	}
+
+	if !f.since.IsZero() {
+		date, err := getAlbumDate(a.path)
+		if err != nil {
+			fmt.Fprintf(w, "Skipping %s, could not read its date (%s)\n", a.rel, err.Error())
+			return false
+		}
+		day, err := util.ParseDate(date)
+		if err != nil {
+			fmt.Fprintf(w, "Skipping %s (%s)\n", a.rel, err.Error())
+			return false
+		}
+		if day.Before(f.since) {
+			return false
+		}
+	}
+
+	if !f.changedSince.IsZero() {
+		changed, err := albumChangedSince(a.path, f.changedSince)
+		if err != nil {
+			fmt.Fprintf(w, "Skipping %s, could not check when it changed (%s)\n", a.rel, err.Error())
+			return false
+		}
+		return changed
+	}
+	return true
+}
+
+// Patterns can match the folder name, like "1990-05-*",
+// or the path from the directory given, like "1990/*/*"
+func albumMatches(a album, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := fpath.Match(pattern, a.name); ok {
+			return true
+		}
+		if ok, _ := fpath.Match(pattern, a.rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+var errAlbumChanged = errors.New("album changed")
+
+// albumChangedSince reports whether anything in the album was modified at or after t.
+// Folders are looked at too, so that deleting a file counts as a change.
+func albumChangedSince(directory string, t time.Time) (bool, error) {
+	err := fpath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(t) {
+			return errAlbumChanged
+		}
+		return nil
+	})
+
+	if err == errAlbumChanged {
+		return true, nil
+	}
+	return false, err
+}
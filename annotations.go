@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/qaisjp/dmlivewiki/util"
+)
+
+// Annotation is a marker put after a track title, like "(*)" for songs sung by Martin
+type Annotation struct {
+	Symbol  string          `yaml:"symbol"`
+	Tooltip string          `yaml:"tooltip"` // shown when hovering over the symbol on the wiki
+	Legend  string          `yaml:"legend"`  // explains the symbol in the information file footer
+	Match   AnnotationMatch `yaml:"match"`
+}
+
+// AnnotationMatch says which songs in the tour file get an annotation.
+// Every rule that is given has to match.
+type AnnotationMatch struct {
+	Vocalist string `yaml:"vocalist,omitempty"` // "*" matches anyone but the lead vocalist
+	Tag      string `yaml:"tag,omitempty"`      // the song has this tag
+	Debut    bool   `yaml:"debut,omitempty"`    // the album is from the date the song debuted
+}
+
+// Used if the config doesn't have any annotations
+var defaultAnnotations = []Annotation{
+	{
+		Symbol:  "(*)",
+		Tooltip: "Vocals by Martin Gore",
+		Legend:  "indicates lead vocals by Martin Gore",
+		Match:   AnnotationMatch{Vocalist: "*"},
+	},
+}
+
+func checkAnnotations(annotations []Annotation) error {
+	seen := make(map[string]bool)
+	for i, annotation := range annotations {
+		if annotation.Symbol == "" {
+			return fmt.Errorf("annotation %d has no symbol", i+1)
+		} else if seen[annotation.Symbol] {
+			return fmt.Errorf("annotation %s is defined twice", annotation.Symbol)
+		}
+		seen[annotation.Symbol] = true
+
+		if annotation.Match == (AnnotationMatch{}) {
+			return fmt.Errorf("annotation %s doesn't match anything", annotation.Symbol)
+		}
+	}
+	return nil
+}
+
+func (m AnnotationMatch) matches(song TourSong, leadVocalist string, date string) bool {
+	if m.Vocalist == "*" {
+		if song.Vocalist == "" || song.Vocalist == leadVocalist {
+			return false
+		}
+	} else if m.Vocalist != "" && !strings.EqualFold(m.Vocalist, song.Vocalist) {
+		return false
+	}
+
+	if m.Tag != "" && !song.hasTag(m.Tag) {
+		return false
+	}
+
+	if m.Debut && !sameDate(song.Debut, date) {
+		return false
+	}
+	return true
+}
+
+func sameDate(a string, b string) bool {
+	x, err := util.ParseDate(a)
+	if err != nil {
+		return false
+	}
+	y, err := util.ParseDate(b)
+	return err == nil && x.Equal(y)
+}
+
+// annotate returns the annotations for a song played on the given date
+func (t *Tour) annotate(title string, date string) (annotations []Annotation) {
+	song, ok := t.song(title)
+	if !ok {
+		return nil
+	}
+
+	for _, annotation := range config.Annotations {
+		if annotation.Match.matches(song, t.leadVocalist, date) {
+			annotations = append(annotations, annotation)
+		}
+	}
+	return
+}
+
+// splitAnnotations takes the annotations off the end of a
+// track title, so "Halo (*)" becomes "Halo" and "(*)"
+func splitAnnotations(title string) (string, []Annotation) {
+	var annotations []Annotation
+
+	for found := true; found; {
+		found = false
+		for _, annotation := range config.Annotations {
+			if trimmed := strings.TrimSuffix(title, " "+annotation.Symbol); trimmed != title {
+				title = strings.TrimSpace(trimmed)
+				annotations = append([]Annotation{annotation}, annotations...)
+				found = true
+			}
+		}
+	}
+	return title, annotations
+}
+
+// usedAnnotations returns every annotation found in the
+// lists given, in the order they are in the config
+func usedAnnotations(lists ...[]Annotation) (used []Annotation) {
+	symbols := make(map[string]bool)
+	for _, list := range lists {
+		for _, annotation := range list {
+			symbols[annotation.Symbol] = true
+		}
+	}
+
+	for _, annotation := range config.Annotations {
+		if symbols[annotation.Symbol] {
+			used = append(used, annotation)
+		}
+	}
+	return
+}
+
+// The legend used to be typed into the footer by hand, but it
+// is generated now, so take it out of old footers
+func removeAnnotationLegends(footer string) string {
+	for _, annotation := range config.Annotations {
+		legend := annotation.Symbol + " " + annotation.Legend
+		footer = strings.Replace(footer, legend+"\n", "", 1)
+		footer = strings.TrimSpace(strings.TrimSuffix(footer, legend))
+	}
+	return footer
+}
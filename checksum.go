@@ -2,22 +2,24 @@ package main
 
 import (
 	"bytes"
-	"crypto/md5"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"hash"
+	"io"
 	"os"
-	"os/exec"
 	fpath "path/filepath"
 	"strings"
+	"sync"
 
+	"github.com/qaisjp/dmlivewiki/flac"
 	"github.com/qaisjp/dmlivewiki/util"
 	"gopkg.in/urfave/cli.v1"
 )
 
-func performChecksum(c *cli.Context) {
-	fileInfo, filepath := util.CheckFilepathArgument(c)
-	if fileInfo == nil {
-		return
+func performChecksum(c *cli.Context) error {
+	fileInfo, filepath, err := util.CheckFilepathArgument(c)
+	if err != nil {
+		return usageError{err}
 	}
 
 	mode := "batch"
@@ -25,64 +27,298 @@ func performChecksum(c *cli.Context) {
 		mode = "single"
 	}
 
+	algos, err := parseChecksumAlgorithms(c.String("algo"))
+	if err != nil {
+		return newUsageError(c, "%s", err.Error())
+	}
+
+	albums, err := findAlbums(c, filepath, fileInfo, os.Stdout)
+	if err != nil {
+		return err
+	}
+
 	fmt.Printf("The following filepath (%s mode) will be processed: %s\n", mode, filepath)
+	printAlbumCount(os.Stdout, albums)
 	util.NotifyDeleteMode(c)
 
 	if !util.ShouldContinue(c) {
-		return
+		return nil
 	}
 
-	workingDirectory, err := os.Getwd()
-	if err != nil {
-		fmt.Println("could not get working directory for some reason")
-		fmt.Println("reason is: " + err.Error())
-		fmt.Println("aborting!")
-		return
+	jobs := c.Int("jobs")
+	if jobs < 1 {
+		jobs = 1
 	}
+	pool := newChecksumPool(jobs, loadChecksumCache(os.Stdout), !c.Bool("full"))
+	out := newOutput(c)
 
-	if mode == "single" {
-		checksumProcessPath(filepath, fileInfo.Name(), c.GlobalBool("delete"), workingDirectory)
-		return
+	batch := newBatchErrors()
+
+	// Albums are processed side by side, and their
+	// files all share the same pool of hashing workers
+	var wg sync.WaitGroup
+	running := make(chan struct{}, jobs)
+
+	// Each album's log is printed in one go when it's done,
+	// so that the albums being processed don't get mixed up
+	var logMu sync.Mutex
+
+	for _, album := range albums {
+		album := album
+
+		wg.Add(1)
+		running <- struct{}{}
+		go func() {
+			defer wg.Done()
+			var log bytes.Buffer
+			err := checksumProcessPath(album.path, album.name, c.GlobalBool("delete"), pool, algos, out, &log)
+
+			logMu.Lock()
+			os.Stdout.Write(log.Bytes())
+			logMu.Unlock()
+
+			batch.add(album.rel, err)
+			<-running
+		}()
 	}
+	wg.Wait()
 
-	files, _ := ioutil.ReadDir(filepath)
-	for _, file := range files {
-		if file.IsDir() {
-			checksumProcessPath(fpath.Join(filepath, file.Name()), file.Name(), c.GlobalBool("delete"), workingDirectory)
+	if hits := pool.cache.cacheHits(); hits > 0 {
+		fmt.Printf("%d unchanged file(s) weren't hashed again (use --full to hash everything)\n", hits)
+	}
+	if !out.dryRun {
+		if err := pool.cache.save(); err != nil {
+			fmt.Println("Could not save the checksum cache:", err.Error())
 		}
 	}
+
+	return batch.err()
+}
+
+// checksumPool bounds how many files are being hashed at once
+type checksumPool struct {
+	slots     chan struct{}
+	cache     *checksumCache
+	useCached bool // false with --full, so that every file is hashed
+}
+
+func newChecksumPool(jobs int, cache *checksumCache, useCached bool) *checksumPool {
+	return &checksumPool{
+		slots:     make(chan struct{}, jobs),
+		cache:     cache,
+		useCached: useCached,
+	}
+}
+
+type checksumResult struct {
+	sums [][]byte // one per algorithm
+	err  error
 }
 
-func checksumProcessPath(directory string, name string, deleteMode bool, workingDirectory string) {
+// hashFiles hashes every path using the pool, returning
+// results in the same order as the paths were given
+func (p *checksumPool) hashFiles(paths []string, algos []checksumAlgorithm) []checksumResult {
+	results := make([]checksumResult, len(paths))
+
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		p.slots <- struct{}{}
+		go func(i int, path string) {
+			defer wg.Done()
+			results[i].sums, results[i].err = p.cache.hashFile(path, algos, p.useCached)
+			<-p.slots
+		}(i, path)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// checksumHashFile streams a file through every algorithm at once,
+// so that large files never have to be held in memory or read twice
+func checksumHashFile(path string, algos []checksumAlgorithm) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hashes := make([]hash.Hash, len(algos))
+	writers := make([]io.Writer, len(algos))
+	for i, algo := range algos {
+		hashes[i] = algo.New()
+		writers[i] = hashes[i]
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return nil, err
+	}
+
+	sums := make([][]byte, len(algos))
+	for i, h := range hashes {
+		sums[i] = h.Sum(nil)
+	}
+	return sums, nil
+}
+
+func checksumProcessPath(directory string, name string, deleteMode bool, pool *checksumPool, algos []checksumAlgorithm, out *output, log io.Writer) error {
+	out = out.to(log)
 	directory = fpath.Clean(directory)
 	baseFilename := fpath.Join(directory, name)
 	ffpFilename := baseFilename + ".ffp"
-	md5Filename := baseFilename + ".md5"
 
-	// If we're in delete mode, let's just delete the ffp and md5 files right away
+	// Manifests already in the album are kept up to date too, even if
+	// their algorithm wasn't chosen this time, so that they never go stale
+	others := checksumOtherManifests(baseFilename, algos)
+	algos = append(algos[:len(algos):len(algos)], others...)
+
+	manifestFilenames := make([]string, len(algos))
+	for i, algo := range algos {
+		manifestFilenames[i] = baseFilename + "." + algo.Name
+	}
+
+	// If we're in delete mode, let's just delete the ffp and manifest files right away
 	if deleteMode {
-		util.RemoveFile(ffpFilename, true)
-		util.RemoveFile(md5Filename, true)
-		return
+		out.removeFile(ffpFilename, true)
+		for _, filename := range manifestFilenames {
+			out.removeFile(filename, true)
+		}
+		return nil
+	}
+
+	for _, algo := range others {
+		fmt.Fprintf(log, "Also updating the %s manifest that's already in %s\n", algo.Name, directory)
 	}
 
-	// Let's create an md5 file buffer and
+	// Let's create a buffer for every manifest,
+	// a list of files to be hashed, and
 	// a pool to store files to be in the ffp
-	var md5Buffer bytes.Buffer
-	ffpPool := []string{
-		// The first arg for ffpPool is this
-		// because we're going to dump the entire
-		// pool in the command later
-		"--show-md5sum",
-		"--no-filename",
+	manifestBuffers := make([]bytes.Buffer, len(algos))
+	hashPool, walkErr := checksumListFiles(directory, name)
+	var ffpPool []string
+
+	// Everything that goes wrong is printed straight away,
+	// and summarised when the album is done
+	var problems []string
+	if walkErr != nil {
+		fmt.Fprintf(log, "!!%s\n", walkErr.Error())
+		problems = append(problems, walkErr.Error())
+	}
+
+	for _, name := range hashPool {
+		if fpath.Ext(name) == ".flac" {
+			// So if the file we have is a flac file,
+			// lets add it to the pool to be checked!
+			ffpPool = append(ffpPool, name)
+		}
+	}
+
+	// Hash everything we found. The results come back in walk order,
+	// so the manifests are the same no matter which file finished first
+	paths := make([]string, len(hashPool))
+	for i, name := range hashPool {
+		paths[i] = fpath.Join(directory, name)
 	}
 
-	// This walks through every file in the folder
-	err := fpath.Walk(directory,
+	for i, result := range pool.hashFiles(paths, algos) {
+		if result.err != nil {
+			fmt.Fprintf(log, "!!Encountered error for: %s\n!!This is the message: %s\n", paths[i], result.err.Error())
+			problems = append(problems, "could not hash "+hashPool[i])
+			continue
+		}
+
+		for j, sum := range result.sums {
+			manifestBuffers[j].WriteString(checksumFormatLine(sum, hashPool[i]))
+		}
+	}
+
+	// If the pool contains atleast one filename
+	if len(ffpPool) > 0 {
+		var hashes []string
+		for _, name := range ffpPool {
+			meta, err := flac.ReadFile(fpath.Join(directory, name))
+			if err != nil {
+				fmt.Fprintf(log, "!!Could not read flac metadata for: %s\n!!Error: %s\n", name, err.Error())
+				problems = append(problems, "could not read flac metadata for "+name)
+				continue
+			}
+
+			hashes = append(hashes, fmt.Sprintf("%s:%s", name, meta.StreamInfo.MD5String()))
+		}
+		data := []byte(strings.Join(hashes, "\r\n"))
+
+		// The manifests don't contain our ffp file, so let's write that to each buffer
+		for i, algo := range algos {
+			h := algo.New()
+			h.Write(data)
+			manifestBuffers[i].WriteString(checksumFormatLine(h.Sum(nil), name+".ffp"))
+		}
+
+		// Let's write the ffp file now
+		if _, err := out.writeFile(ffpFilename, data); err != nil {
+			fmt.Fprintf(log, "!!Could not create ffp file: %s\n!!Error: %s\n", ffpFilename, err.Error())
+			problems = append(problems, "could not create ffp file")
+		}
+	}
+
+	for i, filename := range manifestFilenames {
+		// If the buffer is empty there is nothing to write
+		if manifestBuffers[i].Len() == 0 {
+			continue
+		}
+
+		if _, err := out.writeFile(filename, manifestBuffers[i].Bytes()); err != nil {
+			fmt.Fprintf(log, "!!Could not create %s file: %s\n!!Error: %s\n", algos[i].Name, filename, err.Error())
+			problems = append(problems, "could not create "+algos[i].Name+" file")
+		}
+	}
+
+	fmt.Fprintln(log, "Done with", directory)
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// checksumOtherManifests lists the algorithms that aren't
+// in algos but already have a manifest in the album
+func checksumOtherManifests(baseFilename string, algos []checksumAlgorithm) []checksumAlgorithm {
+	chosen := make(map[string]bool)
+	for _, algo := range algos {
+		chosen[algo.Name] = true
+	}
+
+	var others []checksumAlgorithm
+	for _, algo := range checksumAlgorithms {
+		if chosen[algo.Name] {
+			continue
+		}
+		if _, err := os.Stat(baseFilename + "." + algo.Name); err == nil {
+			others = append(others, algo)
+		}
+	}
+	return others
+}
+
+// checksumListFiles walks through every file in an album, returning names relative
+// to the album. The ffp and manifests aren't included, as they describe the album.
+// Nothing is printed, as verify may be writing a report to stdout.
+func checksumListFiles(directory string, name string) (names []string, err error) {
+	directory = fpath.Clean(directory)
+	baseFilename := fpath.Join(directory, name)
+	ffpFilename := baseFilename + ".ffp"
+
+	var unreadable []string
+	walkErr := fpath.Walk(directory,
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
-				fmt.Println("!!Encountered error for: " + path)
-				fmt.Println("!!This is the message: " + err.Error())
+				var pathErr *os.PathError
+				if errors.As(err, &pathErr) {
+					err = pathErr.Err
+				}
+				unreadable = append(unreadable, fmt.Sprintf("%s (%s)", path, util.GetFileErrorReason(err)))
 				return nil
 			} else if info.IsDir() {
 				// We don't care about directories either,
@@ -90,103 +326,41 @@ func checksumProcessPath(directory string, name string, deleteMode bool, working
 				return nil
 			}
 
-			if (path == ffpFilename) || (path == md5Filename) {
+			if path == ffpFilename || checksumIsManifest(path, baseFilename) {
 				// ffpFilename is hashed afterwards
-				// and we don't need to hash ourself either
+				// and we don't need to hash any manifest either
 				return nil
 			}
 
-			name := strings.TrimPrefix(
+			names = append(names, strings.TrimPrefix(
 				path,
 				directory+string(os.PathSeparator),
-			)
-
-			if fpath.Ext(path) == ".flac" {
-				// So if the file we have is an ffp file,
-				// lets add it to the pool to be checked!
-				ffpPool = append(ffpPool, name)
-			}
-
-			// Read the file
-			data, err := ioutil.ReadFile(path)
-
-			if err != nil {
-				fmt.Println("!!Encountered error for: " + path)
-				fmt.Println("!!This is the message: " + err.Error())
-			}
-
-			md5Buffer.WriteString(checksumFormatMD5(md5.Sum(data), strings.TrimPrefix(name, directory+"/")))
+			))
 			return nil
 		},
 	)
 
-	if err != nil {
-		fmt.Println("!!Error while walking through directory: " + directory)
-		fmt.Printf("!!Error: %s\n", err.Error())
+	if walkErr != nil {
+		return names, fmt.Errorf("could not walk through %s: %w", directory, walkErr)
 	}
 
-	// If the pool contains atleast one **filename**
-	// the first two items in the pool is actually just a flag!
-	if len(ffpPool) > 2 {
-		cmd := exec.Command(metaflacPath, ffpPool[:]...)
-		cmd.Dir = directory
-
-		data, err := cmd.Output()
-		if err != nil {
-			fmt.Println("metaflac returned an invalid response")
-			if data != nil {
-				fmt.Println(data)
-			}
-			panic(err)
-		}
-
-		hashes := strings.Split(string(data), "\n")
-		for i, hash := range hashes {
-			if (i == len(hashes)-1) && (hash == "") {
-				// Last line is an empty line
-				continue
-			}
-
-			hashes[i] = strings.TrimSpace(fmt.Sprintf("%s:%s", strings.TrimPrefix(ffpPool[i+2], directory), hash))
-		}
-		data = []byte(strings.Join(hashes, "\r\n"))
-
-		// The md5 buffer doesn't contain our ffp file, so let's write that to the buffer
-		md5Buffer.WriteString(checksumFormatMD5(md5.Sum(data), name+".ffp"))
-
-		// Let's write the ffp file now
-		ffp, err := os.Create(ffpFilename)
-		if err != nil {
-			fmt.Println("!!Could not create ffp file: " + ffpFilename)
-			fmt.Println("!!Error: " + err.Error())
-		} else {
-			defer ffp.Close()
-			_, err := ffp.Write(data)
-			if err != nil {
-				panic(err)
-			}
-		}
+	if len(unreadable) > 0 {
+		err = fmt.Errorf("could not read %s", strings.Join(unreadable, "; "))
 	}
+	return
+}
 
-	// If the md5buffer isn't empty
-	if md5Buffer.Len() > 0 {
-		// Let's write the md5 file
-		md5, err := os.Create(md5Filename)
-		if err != nil {
-			fmt.Println("!!Could not create md5 file: " + md5Filename)
-			fmt.Println("!!Error: " + err.Error())
-		} else {
-			defer md5.Close()
-			_, err := md5.Write(md5Buffer.Bytes())
-			if err != nil {
-				panic(err)
-			}
+// checksumIsManifest reports whether path is a manifest of any known algorithm,
+// so that manifests from earlier runs are never hashed
+func checksumIsManifest(path string, baseFilename string) bool {
+	for _, algo := range checksumAlgorithms {
+		if path == baseFilename+"."+algo.Name {
+			return true
 		}
 	}
-
-	fmt.Println("Done with", directory)
+	return false
 }
 
-func checksumFormatMD5(hash [16]byte, name string) string {
+func checksumFormatLine(hash []byte, name string) string {
 	return fmt.Sprintf("%x *%s\r\n", hash, name)
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// checksumAlgorithm is a hash that can be written to a manifest
+// alongside the album, named albumFolderName.<Name>
+type checksumAlgorithm struct {
+	Name string
+	New  func() hash.Hash
+}
+
+// The order here is the order manifests are written and verified in
+var checksumAlgorithms = []checksumAlgorithm{
+	{"md5", md5.New},
+	{"sha1", sha1.New},
+	{"sha256", sha256.New},
+	{"blake2b", newBlake2b},
+}
+
+func newBlake2b() hash.Hash {
+	// only errors when given a key that is too long
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+// size of a hex encoded hash in a manifest
+func (algo checksumAlgorithm) hexLength() int {
+	return algo.New().Size() * 2
+}
+
+// parseChecksumAlgorithms turns a list like "md5,sha256" into algorithms
+func parseChecksumAlgorithms(list string) (algos []checksumAlgorithm, err error) {
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+
+		algo, ok := findChecksumAlgorithm(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown checksum algorithm %q (choose from %s)", name, checksumAlgorithmNames())
+		}
+
+		seen[name] = true
+		algos = append(algos, algo)
+	}
+
+	if len(algos) == 0 {
+		return nil, errors.New("no checksum algorithm given")
+	}
+	return
+}
+
+func findChecksumAlgorithm(name string) (checksumAlgorithm, bool) {
+	for _, algo := range checksumAlgorithms {
+		if algo.Name == name {
+			return algo, true
+		}
+	}
+	return checksumAlgorithm{}, false
+}
+
+func checksumAlgorithmNames() string {
+	names := make([]string, len(checksumAlgorithms))
+	for i, algo := range checksumAlgorithms {
+		names[i] = algo.Name
+	}
+	return strings.Join(names, ", ")
+}
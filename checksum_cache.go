@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	fpath "path/filepath"
+	"sync"
+)
+
+// checksumCache remembers the hashes of every file that has been hashed, so
+// that files that haven't changed since don't have to be read again. A file
+// has changed if its size, modification time or inode are different.
+//
+// A nil *checksumCache can be used, it just never has anything cached.
+type checksumCache struct {
+	filename string
+	mu       sync.Mutex
+	files    map[string]checksumCacheEntry // by absolute path
+	changed  bool
+	hits     int
+}
+
+type checksumCacheEntry struct {
+	Size    int64             `json:"size"`
+	ModTime int64             `json:"mtime"` // in nanoseconds
+	Inode   uint64            `json:"inode,omitempty"`
+	Sums    map[string]string `json:"sums"` // algorithm name to the hex encoded hash
+}
+
+// checksumCacheFilename is where the cache is kept, which is
+// checksumCache from the config or the user's cache folder
+func checksumCacheFilename() (string, error) {
+	if config.ChecksumCache != "" {
+		return config.ChecksumCache, nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return fpath.Join(dir, "dmlivewiki", "checksums.json"), nil
+}
+
+// loadChecksumCache reads the cache. If it can't be used the reason is
+// printed on w and nil is returned, as everything still works without it.
+func loadChecksumCache(w io.Writer) *checksumCache {
+	filename, err := checksumCacheFilename()
+	if err != nil {
+		fmt.Fprintln(w, "Not using the checksum cache:", err.Error())
+		return nil
+	}
+
+	cache := &checksumCache{
+		filename: filename,
+		files:    make(map[string]checksumCacheEntry),
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return cache
+	} else if err != nil {
+		fmt.Fprintln(w, "Not using the checksum cache:", err.Error())
+		return nil
+	}
+
+	if err := json.Unmarshal(data, &cache.files); err != nil {
+		// it's only a cache, so start again
+		fmt.Fprintf(w, "The checksum cache %s couldn't be read and will be rebuilt (%s)\n", filename, err.Error())
+		cache.files = make(map[string]checksumCacheEntry)
+	}
+	return cache
+}
+
+// save writes the cache back out, if anything was added to it
+func (cc *checksumCache) save() error {
+	if cc == nil {
+		return nil
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if !cc.changed {
+		return nil
+	}
+
+	data, err := json.Marshal(cc.files)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(fpath.Dir(cc.filename), os.ModePerm); err != nil {
+		return err
+	}
+
+	// write somewhere else first, so the cache is never left half written
+	temp := cc.filename + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, cc.filename); err != nil {
+		return err
+	}
+
+	cc.changed = false
+	return nil
+}
+
+// hashFile hashes a file with every algorithm, using the
+// hashes in the cache if useCached is set and the file hasn't changed
+func (cc *checksumCache) hashFile(path string, algos []checksumAlgorithm, useCached bool) ([][]byte, error) {
+	if cc == nil {
+		return checksumHashFile(path, algos)
+	}
+
+	path, err := fpath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// stat before hashing, so that if the file is changed
+	// while it is being read it is hashed again next time
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if useCached {
+		if sums, ok := cc.lookup(path, info, algos); ok {
+			return sums, nil
+		}
+	}
+
+	sums, err := checksumHashFile(path, algos)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.store(path, info, algos, sums)
+	return sums, nil
+}
+
+func (cc *checksumCache) lookup(path string, info os.FileInfo, algos []checksumAlgorithm) ([][]byte, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	entry, ok := cc.files[path]
+	if !ok || !entry.matches(info) {
+		return nil, false
+	}
+
+	sums := make([][]byte, len(algos))
+	for i, algo := range algos {
+		sum, err := hex.DecodeString(entry.Sums[algo.Name])
+		if err != nil || len(sum) == 0 {
+			// this algorithm hasn't been used on the file yet
+			return nil, false
+		}
+		sums[i] = sum
+	}
+
+	cc.hits++
+	return sums, true
+}
+
+func (cc *checksumCache) store(path string, info os.FileInfo, algos []checksumAlgorithm, sums [][]byte) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	// keep the hashes of other algorithms if the file is the same
+	entry, ok := cc.files[path]
+	if !ok || !entry.matches(info) {
+		entry = checksumCacheEntry{
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+			Inode:   fileInode(info),
+			Sums:    make(map[string]string),
+		}
+	}
+
+	for i, algo := range algos {
+		entry.Sums[algo.Name] = hex.EncodeToString(sums[i])
+	}
+
+	cc.files[path] = entry
+	cc.changed = true
+}
+
+func (e checksumCacheEntry) matches(info os.FileInfo) bool {
+	return e.Size == info.Size() &&
+		e.ModTime == info.ModTime().UnixNano() &&
+		e.Inode == fileInode(info)
+}
+
+// cacheHits returns how many files didn't have to be hashed
+func (cc *checksumCache) cacheHits() int {
+	if cc == nil {
+		return 0
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.hits
+}
@@ -0,0 +1,11 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package main
+
+import "os"
+
+// Windows doesn't give us inodes, so the checksum
+// cache only uses the size and modification time
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -2,20 +2,37 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
-	"strings"
+	fpath "path/filepath"
 
 	"gopkg.in/yaml.v2"
 )
 
-var config struct {
-	BaseDomain   string `yaml:"baseDomain"`
-	WikiPath     string `yaml:"wikiPath"`
-	StreamPath   string `yaml:"streamPath"`
-	DownloadPath string `yaml:"downloadPath"`
-	Footer       string `yaml:"footer"`
+// Config is also available to templates as .Config
+type Config struct {
+	BaseDomain       string   `yaml:"baseDomain"`
+	WikiPath         string   `yaml:"wikiPath"`
+	StreamPath       string   `yaml:"streamPath"`
+	DownloadPath     string   `yaml:"downloadPath"`
+	Footer           string   `yaml:"footer"`
+	VerifyIgnore     []string `yaml:"verifyIgnore"`
+	InfoTemplateFile string   `yaml:"infoTemplateFile"`
+	WikiTemplateFile string   `yaml:"wikiTemplateFile"`
+	ChecksumCache    string   `yaml:"checksumCache"`
+	APIURL           string   `yaml:"apiURL"`
+	BotUsername      string   `yaml:"botUsername"`
+
+	Sources map[string]int `yaml:"sources"` // album folder name to its source number
+
+	Annotations []Annotation `yaml:"annotations"`
 }
 
+var config Config
+
+// Files that verify won't complain about if they aren't in a manifest
+var defaultVerifyIgnore = []string{"Thumbs.db", "desktop.ini", ".DS_Store", "._*"}
+
 func parseConfig(path string) (err error) {
 	if path == "" {
 		return errors.New("config path missing. don't forget to provide the environment variable")
@@ -47,15 +64,42 @@ func parseConfig(path string) (err error) {
 		config.WikiPath = config.BaseDomain + "/wiki"
 	}
 
+	if config.APIURL == "" {
+		config.APIURL = config.BaseDomain + "/api.php"
+	}
+
 	if config.DownloadPath == "" {
 		config.DownloadPath = config.BaseDomain + "/downloads"
 	}
 
-	informationTemplate = strings.Replace(informationTemplate, "$$wikiPath$$", config.WikiPath, -1)
-	informationTemplate = strings.Replace(informationTemplate, "$$footer$$", config.Footer, -1)
+	for name, source := range config.Sources {
+		if source < 1 {
+			return fmt.Errorf("sources config field gives %s Source %d, sources start at 1", name, source)
+		}
+	}
 
-	wikiTemplate = strings.Replace(wikiTemplate, "$$streamPath$$", config.StreamPath, -1)
-	wikiTemplate = strings.Replace(wikiTemplate, "$$downloadPath$$", config.DownloadPath, -1)
+	if config.VerifyIgnore == nil {
+		config.VerifyIgnore = defaultVerifyIgnore
+	}
+
+	if config.Annotations == nil {
+		config.Annotations = defaultAnnotations
+	} else if err := checkAnnotations(config.Annotations); err != nil {
+		return err
+	}
+	config.Footer = removeAnnotationLegends(config.Footer)
+
+	// Template files and the cache are relative to the config file
+	config.InfoTemplateFile = configRelativePath(path, config.InfoTemplateFile)
+	config.WikiTemplateFile = configRelativePath(path, config.WikiTemplateFile)
+	config.ChecksumCache = configRelativePath(path, config.ChecksumCache)
 
 	return
 }
+
+func configRelativePath(configPath string, path string) string {
+	if path == "" || fpath.IsAbs(path) {
+		return path
+	}
+	return fpath.Join(fpath.Dir(configPath), path)
+}
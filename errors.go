@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Exit codes, so that scripts wrapping dmlivewiki can tell what happened
+const (
+	exitFailure = 1 // nothing could be processed
+	exitUsage   = 2 // bad arguments, flags or config
+	exitPartial = 3 // some albums failed, but the rest were processed
+	exitEdited  = 4 // wiki diff found pages that publish would overwrite someone's edits to
+)
+
+// usageError is returned when the command was called incorrectly
+type usageError struct {
+	error
+}
+
+func (e usageError) ExitCode() int {
+	return exitUsage
+}
+
+// newUsageError shows the help for the current command and returns an error to exit with
+func newUsageError(c *cli.Context, format string, args ...interface{}) error {
+	if err := cli.ShowSubcommandHelp(c); err != nil {
+		fmt.Println("Error:", err.Error())
+	}
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// onUsageError is used for flags that can't be parsed
+func onUsageError(c *cli.Context, err error, isSubcommand bool) error {
+	return newUsageError(c, "incorrect usage: %s", err.Error())
+}
+
+// batchErrors collects the albums that failed while processing a batch.
+// It is safe to use from multiple goroutines.
+type batchErrors struct {
+	mu       sync.Mutex
+	total    int
+	failures map[string]error
+}
+
+func newBatchErrors() *batchErrors {
+	return &batchErrors{failures: make(map[string]error)}
+}
+
+// add records the outcome of processing an album, where err is nil on success
+func (b *batchErrors) add(album string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.total++
+	if err != nil {
+		b.failures[album] = err
+	}
+}
+
+// err returns an error summarising every failure, or nil if there were none
+func (b *batchErrors) err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.failures) == 0 {
+		return nil
+	}
+
+	albums := make([]string, 0, len(b.failures))
+	for album := range b.failures {
+		albums = append(albums, album)
+	}
+	sort.Strings(albums)
+
+	summary := &batchError{total: b.total}
+	for _, album := range albums {
+		summary.albums = append(summary.albums, album)
+		summary.errors = append(summary.errors, b.failures[album])
+	}
+	return summary
+}
+
+// batchError is the summary of a batch in which at least one album failed
+type batchError struct {
+	total  int
+	albums []string
+	errors []error
+}
+
+func (e *batchError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d albums failed:", len(e.albums), e.total)
+	for i, album := range e.albums {
+		fmt.Fprintf(&b, "\n - %s: %s", album, e.errors[i].Error())
+	}
+	return b.String()
+}
+
+func (e *batchError) ExitCode() int {
+	if len(e.albums) < e.total {
+		return exitPartial
+	}
+	return exitFailure
+}
@@ -2,20 +2,17 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	fpath "path/filepath"
-	"regexp"
-	"strings"
 
 	"github.com/qaisjp/dmlivewiki/util"
 	"gopkg.in/urfave/cli.v1"
 )
 
-func findWikifiles(c *cli.Context) {
-	fileInfo, filepath := util.CheckFilepathArgument(c)
-	if fileInfo == nil {
-		return
+func findWikifiles(c *cli.Context) error {
+	fileInfo, filepath, err := util.CheckFilepathArgument(c)
+	if err != nil {
+		return usageError{err}
 	}
 
 	mode := "batch"
@@ -23,53 +20,42 @@ func findWikifiles(c *cli.Context) {
 		mode = "single"
 	}
 
+	albums, err := findAlbums(c, filepath, fileInfo, os.Stdout)
+	if err != nil {
+		return err
+	}
+
 	fmt.Printf("The following filepath (%s mode) will be processed: %s\n", mode, filepath)
+	printAlbumCount(os.Stdout, albums)
 	util.NotifyDeleteMode(c)
 
 	if !util.ShouldContinue(c) {
-		return
+		return nil
 	}
 
-	wikiRegex = regexp.MustCompile(wikiRegexText)
-
-	if mode == "single" {
-		findWikifile(filepath, fileInfo.Name())
-		return
-	}
-
-	files, _ := ioutil.ReadDir(filepath)
-	for _, file := range files {
-		if file.IsDir() {
-			name := file.Name()
-			if name != "__wikifiles" {
-				findWikifile(fpath.Join(filepath, name), name)
-			}
-		}
+	batch := newBatchErrors()
+	for _, album := range albums {
+		batch.add(album.rel, findWikifile(album.path, album.name))
 	}
+	return batch.err()
 }
 
-func findWikifile(filepath string, foldername string) {
+// findWikifile only returns an error if the infofile couldn't be read or parsed
+func findWikifile(filepath string, foldername string) error {
 	infofile := fpath.Join(filepath, foldername+".txt")
 
-	infobytes, err := ioutil.ReadFile(infofile)
+	info, err := readInfoFile(infofile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			fmt.Println("No infofile for", infofile)
-		} else {
-			fmt.Printf("error in %s (%s) \n", infofile, err.Error())
+			return nil
 		}
-		return
+		fmt.Printf("error in %s (%s) \n", infofile, err.Error())
+		return err
 	}
 
-	matches := wikiRegex.FindSubmatch(infobytes)
-	if len(matches) != 1+wikiRegex.NumSubexp() {
-		// (entire string itself)+(capture groups)
-		fmt.Printf("parse failure, expected %d capturing groups!\n", 1+wikiRegex.NumSubexp())
-		return
-	}
-
-	if strings.TrimSpace(string(matches[3])) == "" {
+	if info.Notes == "" {
 		fmt.Println("Notes unfilled for", infofile)
 	}
-
+	return nil
 }
@@ -0,0 +1,106 @@
+package flac
+
+import (
+	"io"
+	"math/bits"
+)
+
+// bitReader reads big endian bit fields, keeping running
+// checksums of every byte pulled from the underlying reader
+type bitReader struct {
+	r     io.ByteReader
+	cache uint64 // unread bits are the low n bits
+	n     uint
+	crc8  uint8
+	crc16 uint16
+}
+
+func newBitReader(r io.ByteReader) *bitReader {
+	return &bitReader{r: r}
+}
+
+// resetCRC starts a new checksum, which must be done at a byte boundary
+func (br *bitReader) resetCRC() {
+	br.crc8 = 0
+	br.crc16 = 0
+}
+
+func (br *bitReader) fill() error {
+	b, err := br.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	br.crc8 = crc8Table[br.crc8^b]
+	br.crc16 = br.crc16<<8 ^ crc16Table[uint8(br.crc16>>8)^b]
+	br.cache = br.cache<<8 | uint64(b)
+	br.n += 8
+	return nil
+}
+
+// read returns the next n (up to 32) bits as an unsigned integer
+func (br *bitReader) read(n uint) (uint64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	for br.n < n {
+		if err := br.fill(); err != nil {
+			return 0, unexpected(err)
+		}
+	}
+	br.n -= n
+	return br.cache >> br.n & (1<<n - 1), nil
+}
+
+// readSigned returns the next n (up to 33) bits as a two's complement integer
+func (br *bitReader) readSigned(n uint) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if n > 32 {
+		hi, err := br.read(n - 32)
+		if err != nil {
+			return 0, err
+		}
+		lo, err := br.read(32)
+		if err != nil {
+			return 0, err
+		}
+		v := hi<<32 | lo
+		return int64(v<<(64-n)) >> (64 - n), nil
+	}
+
+	v, err := br.read(n)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary counts the zero bits before the next one bit
+func (br *bitReader) readUnary() (uint64, error) {
+	var count uint64
+	for {
+		if br.n == 0 {
+			if err := br.fill(); err != nil {
+				return 0, unexpected(err)
+			}
+		}
+
+		window := br.cache << (64 - br.n)
+		if window == 0 {
+			count += uint64(br.n)
+			br.n = 0
+			continue
+		}
+
+		zeros := uint(bits.LeadingZeros64(window))
+		count += uint64(zeros)
+		br.n -= zeros + 1
+		return count, nil
+	}
+}
+
+// align discards bits up to the next byte boundary
+func (br *bitReader) align() {
+	br.n -= br.n % 8
+}
@@ -0,0 +1,27 @@
+package flac
+
+// crc8 covers the frame header, crc16 covers the entire frame
+var crc8Table, crc16Table = makeCRCTables()
+
+func makeCRCTables() (t8 [256]uint8, t16 [256]uint16) {
+	for i := 0; i < 256; i++ {
+		c8 := uint8(i)
+		c16 := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		t8[i] = c8
+		t16[i] = c16
+	}
+	return
+}
@@ -0,0 +1,609 @@
+package flac
+
+import (
+	"bufio"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// FrameHeader describes the audio contained in a single frame
+type FrameHeader struct {
+	VariableBlockSize bool
+	Number            uint64 // frame number, or the first sample number if the block size is variable
+	BlockSize         int
+	SampleRate        uint32
+	ChannelAssignment uint8
+	Channels          uint8
+	BitsPerSample     uint8
+}
+
+// Frame is a single decoded audio frame, with one slice of samples per channel
+type Frame struct {
+	Header  FrameHeader
+	Samples [][]int32
+}
+
+// CRCError is returned by Decoder.Next when a frame doesn't match its CRC-16.
+// The decoded frame is still returned alongside it.
+type CRCError struct {
+	Frame    int // index of the frame, starting at 0
+	Expected uint16
+	Actual   uint16
+}
+
+func (e *CRCError) Error() string {
+	return fmt.Sprintf("flac: frame %d failed CRC-16 (expected %04x, got %04x)", e.Frame, e.Expected, e.Actual)
+}
+
+const (
+	channelsLeftSide  = 8
+	channelsSideRight = 9
+	channelsMidSide   = 10
+)
+
+// Decoder reads audio frames from a FLAC stream
+type Decoder struct {
+	Metadata *Metadata
+
+	br      *bitReader
+	frame   int    // index of the next frame
+	samples uint64 // samples per channel decoded so far
+}
+
+// NewDecoder reads the metadata from r and prepares to decode the frames that follow
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	buffered, ok := r.(*bufio.Reader)
+	if !ok {
+		buffered = bufio.NewReader(r)
+	}
+
+	meta, err := ReadMetadata(buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decoder{
+		Metadata: meta,
+		br:       newBitReader(buffered),
+	}, nil
+}
+
+// Next decodes the next frame, returning io.EOF once every sample has been read
+func (d *Decoder) Next() (*Frame, error) {
+	total := d.Metadata.StreamInfo.TotalSamples
+	if total != 0 && d.samples >= total {
+		// anything after the last sample is junk (like an ID3v1 tag)
+		return nil, io.EOF
+	}
+
+	br := d.br
+	br.resetCRC()
+
+	sync, err := br.read(8)
+	if err == io.ErrUnexpectedEOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	header, err := d.readFrameHeader(sync)
+	if err != nil {
+		return nil, fmt.Errorf("flac: frame %d: %w", d.frame, err)
+	}
+
+	frame := &Frame{
+		Header:  header,
+		Samples: make([][]int32, header.Channels),
+	}
+
+	for ch := range frame.Samples {
+		bps := uint(header.BitsPerSample)
+		switch {
+		case header.ChannelAssignment == channelsLeftSide && ch == 1,
+			header.ChannelAssignment == channelsSideRight && ch == 0,
+			header.ChannelAssignment == channelsMidSide && ch == 1:
+			// side channels need an extra bit
+			bps++
+		}
+
+		frame.Samples[ch], err = d.readSubframe(header.BlockSize, bps)
+		if err != nil {
+			return nil, fmt.Errorf("flac: frame %d channel %d: %w", d.frame, ch, err)
+		}
+	}
+
+	decorrelate(header.ChannelAssignment, frame.Samples)
+
+	br.align()
+	actual := br.crc16
+	expected, err := br.read(16)
+	if err != nil {
+		return nil, fmt.Errorf("flac: frame %d: reading CRC-16: %w", d.frame, err)
+	}
+
+	index := d.frame
+	d.frame++
+	d.samples += uint64(header.BlockSize)
+
+	if uint16(expected) != actual {
+		return frame, &CRCError{Frame: index, Expected: uint16(expected), Actual: actual}
+	}
+	return frame, nil
+}
+
+var blockSizes = [16]int{0, 192, 576, 1152, 2304, 4608, 0, 0, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768}
+
+var sampleRates = [12]uint32{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+
+var sampleSizes = [8]uint8{0, 8, 12, 0, 16, 20, 24, 32}
+
+func (d *Decoder) readFrameHeader(sync uint64) (h FrameHeader, err error) {
+	br := d.br
+	info := d.Metadata.StreamInfo
+
+	rest, err := br.read(24)
+	if err != nil {
+		return
+	}
+	fields := sync<<24 | rest
+
+	if fields>>18 != 0x3ffe {
+		return h, errors.New("lost frame sync")
+	}
+	if fields>>17&1 != 0 {
+		return h, errors.New("reserved frame header bit is set")
+	}
+
+	h.VariableBlockSize = fields>>16&1 != 0
+	blockSizeCode := fields >> 12 & 0xf
+	sampleRateCode := fields >> 8 & 0xf
+	h.ChannelAssignment = uint8(fields >> 4 & 0xf)
+	sampleSizeCode := fields >> 1 & 0x7
+
+	if h.Number, err = d.readUTF8(); err != nil {
+		return
+	}
+
+	switch {
+	case blockSizeCode == 0:
+		return h, errors.New("reserved block size")
+	case blockSizeCode == 6:
+		size, err := br.read(8)
+		if err != nil {
+			return h, err
+		}
+		h.BlockSize = int(size) + 1
+	case blockSizeCode == 7:
+		size, err := br.read(16)
+		if err != nil {
+			return h, err
+		}
+		h.BlockSize = int(size) + 1
+	default:
+		h.BlockSize = blockSizes[blockSizeCode]
+	}
+
+	switch {
+	case sampleRateCode == 0:
+		h.SampleRate = info.SampleRate
+	case sampleRateCode < 12:
+		h.SampleRate = sampleRates[sampleRateCode]
+	case sampleRateCode == 12:
+		rate, err := br.read(8)
+		if err != nil {
+			return h, err
+		}
+		h.SampleRate = uint32(rate) * 1000
+	case sampleRateCode == 13:
+		rate, err := br.read(16)
+		if err != nil {
+			return h, err
+		}
+		h.SampleRate = uint32(rate)
+	case sampleRateCode == 14:
+		rate, err := br.read(16)
+		if err != nil {
+			return h, err
+		}
+		h.SampleRate = uint32(rate) * 10
+	default:
+		return h, errors.New("invalid sample rate")
+	}
+
+	switch {
+	case h.ChannelAssignment < 8:
+		h.Channels = h.ChannelAssignment + 1
+	case h.ChannelAssignment <= channelsMidSide:
+		h.Channels = 2
+	default:
+		return h, errors.New("reserved channel assignment")
+	}
+
+	if sampleSizeCode == 0 {
+		h.BitsPerSample = info.BitsPerSample
+	} else if h.BitsPerSample = sampleSizes[sampleSizeCode]; h.BitsPerSample == 0 {
+		return h, errors.New("reserved sample size")
+	}
+
+	actual := br.crc8
+	expected, err := br.read(8)
+	if err != nil {
+		return
+	}
+	if uint8(expected) != actual {
+		return h, fmt.Errorf("header failed CRC-8 (expected %02x, got %02x)", expected, actual)
+	}
+
+	return h, nil
+}
+
+// readUTF8 reads the frame or sample number, which is stored like a UTF-8 code point
+func (d *Decoder) readUTF8() (uint64, error) {
+	first, err := d.br.read(8)
+	if err != nil {
+		return 0, err
+	}
+
+	var extra int
+	var value uint64
+	switch {
+	case first&0x80 == 0:
+		return first, nil
+	case first&0xe0 == 0xc0:
+		extra, value = 1, first&0x1f
+	case first&0xf0 == 0xe0:
+		extra, value = 2, first&0x0f
+	case first&0xf8 == 0xf0:
+		extra, value = 3, first&0x07
+	case first&0xfc == 0xf8:
+		extra, value = 4, first&0x03
+	case first&0xfe == 0xfc:
+		extra, value = 5, first&0x01
+	case first == 0xfe:
+		extra, value = 6, 0
+	default:
+		return 0, errors.New("invalid frame number encoding")
+	}
+
+	for i := 0; i < extra; i++ {
+		b, err := d.br.read(8)
+		if err != nil {
+			return 0, err
+		}
+		if b&0xc0 != 0x80 {
+			return 0, errors.New("invalid frame number encoding")
+		}
+		value = value<<6 | b&0x3f
+	}
+	return value, nil
+}
+
+func (d *Decoder) readSubframe(blockSize int, bps uint) ([]int32, error) {
+	br := d.br
+
+	header, err := br.read(8)
+	if err != nil {
+		return nil, err
+	}
+	if header&0x80 != 0 {
+		return nil, errors.New("subframe padding bit is set")
+	}
+	kind := header >> 1 & 0x3f
+
+	var wasted uint
+	if header&1 != 0 {
+		k, err := br.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = uint(k) + 1
+		if wasted >= bps {
+			return nil, errors.New("too many wasted bits")
+		}
+		bps -= wasted
+	}
+
+	samples := make([]int32, blockSize)
+	switch {
+	case kind == 0:
+		err = d.readConstant(samples, bps)
+	case kind == 1:
+		err = d.readVerbatim(samples, bps)
+	case kind >= 8 && kind <= 12:
+		err = d.readFixed(samples, bps, int(kind&0x7))
+	case kind >= 32:
+		err = d.readLPC(samples, bps, int(kind&0x1f)+1)
+	default:
+		err = fmt.Errorf("reserved subframe type %d", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+	return samples, nil
+}
+
+func (d *Decoder) readConstant(samples []int32, bps uint) error {
+	value, err := d.br.readSigned(bps)
+	if err != nil {
+		return err
+	}
+	for i := range samples {
+		samples[i] = int32(value)
+	}
+	return nil
+}
+
+func (d *Decoder) readVerbatim(samples []int32, bps uint) error {
+	for i := range samples {
+		value, err := d.br.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		samples[i] = int32(value)
+	}
+	return nil
+}
+
+var fixedCoefficients = [5][]int64{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+func (d *Decoder) readFixed(samples []int32, bps uint, order int) error {
+	if order > len(samples) {
+		return errors.New("fixed predictor order is larger than the block")
+	}
+
+	for i := 0; i < order; i++ {
+		value, err := d.br.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		samples[i] = int32(value)
+	}
+
+	if err := d.readResidual(samples, order); err != nil {
+		return err
+	}
+
+	predict(samples, fixedCoefficients[order], 0)
+	return nil
+}
+
+func (d *Decoder) readLPC(samples []int32, bps uint, order int) error {
+	br := d.br
+	if order > len(samples) {
+		return errors.New("LPC order is larger than the block")
+	}
+
+	for i := 0; i < order; i++ {
+		value, err := br.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		samples[i] = int32(value)
+	}
+
+	precision, err := br.read(4)
+	if err != nil {
+		return err
+	}
+	if precision == 0xf {
+		return errors.New("invalid LPC coefficient precision")
+	}
+	precision++
+
+	shift, err := br.readSigned(5)
+	if err != nil {
+		return err
+	}
+	if shift < 0 {
+		return errors.New("negative LPC shift")
+	}
+
+	coefficients := make([]int64, order)
+	for i := range coefficients {
+		if coefficients[i], err = br.readSigned(uint(precision)); err != nil {
+			return err
+		}
+	}
+
+	if err := d.readResidual(samples, order); err != nil {
+		return err
+	}
+
+	predict(samples, coefficients, uint(shift))
+	return nil
+}
+
+// predict adds the prediction to the residuals stored after the warm up samples
+func predict(samples []int32, coefficients []int64, shift uint) {
+	order := len(coefficients)
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range coefficients {
+			sum += c * int64(samples[i-1-j])
+		}
+		samples[i] += int32(sum >> shift)
+	}
+}
+
+// readResidual stores the rice coded residual in samples[order:]
+func (d *Decoder) readResidual(samples []int32, order int) error {
+	br := d.br
+
+	method, err := br.read(2)
+	if err != nil {
+		return err
+	}
+
+	var paramBits uint
+	var escape uint64
+	switch method {
+	case 0:
+		paramBits, escape = 4, 0xf
+	case 1:
+		paramBits, escape = 5, 0x1f
+	default:
+		return errors.New("reserved residual coding method")
+	}
+
+	partitionOrder, err := br.read(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	partitionSize := len(samples) >> partitionOrder
+	if partitionSize<<partitionOrder != len(samples) || partitionSize < order {
+		return errors.New("invalid residual partition order")
+	}
+
+	i := order
+	for p := 0; p < partitions; p++ {
+		end := (p + 1) * partitionSize
+
+		param, err := br.read(paramBits)
+		if err != nil {
+			return err
+		}
+
+		if param == escape {
+			rawBits, err := br.read(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				value, err := br.readSigned(uint(rawBits))
+				if err != nil {
+					return err
+				}
+				samples[i] = int32(value)
+			}
+			continue
+		}
+
+		for ; i < end; i++ {
+			high, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			low, err := br.read(uint(param))
+			if err != nil {
+				return err
+			}
+
+			folded := high<<param | low
+			samples[i] = int32(folded>>1) ^ -int32(folded&1)
+		}
+	}
+	return nil
+}
+
+func decorrelate(assignment uint8, samples [][]int32) {
+	switch assignment {
+	case channelsLeftSide:
+		left, side := samples[0], samples[1]
+		for i := range side {
+			side[i] = left[i] - side[i]
+		}
+	case channelsSideRight:
+		side, right := samples[0], samples[1]
+		for i := range side {
+			side[i] += right[i]
+		}
+	case channelsMidSide:
+		mid, side := samples[0], samples[1]
+		for i := range side {
+			m := int64(mid[i])<<1 | int64(side[i])&1
+			s := int64(side[i])
+			mid[i] = int32((m + s) >> 1)
+			side[i] = int32((m - s) >> 1)
+		}
+	}
+}
+
+// AudioCheck is the result of decoding every frame of a FLAC file
+type AudioCheck struct {
+	Expected  [16]byte // the signature stored in STREAMINFO
+	Actual    [16]byte // the signature of the decoded audio
+	Samples   uint64   // samples per channel that were decoded
+	BadFrames []int    // frames that failed their CRC-16
+}
+
+// OK reports whether the decoded audio matches the stored signature
+func (a *AudioCheck) OK() bool {
+	return a.Expected == a.Actual && len(a.BadFrames) == 0
+}
+
+// CheckAudio decodes an entire FLAC stream and hashes the audio the same way the encoder did
+func CheckAudio(r io.Reader) (*AudioCheck, error) {
+	dec, err := NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+
+	info := dec.Metadata.StreamInfo
+	check := &AudioCheck{Expected: info.MD5}
+
+	hash := md5.New()
+	bytesPerSample := int(info.BitsPerSample+7) / 8
+	var buf []byte
+
+	for {
+		frame, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+
+		var crcErr *CRCError
+		if errors.As(err, &crcErr) {
+			check.BadFrames = append(check.BadFrames, crcErr.Frame)
+		} else if err != nil {
+			return check, err
+		}
+
+		// samples are interleaved and little endian, using as few bytes as possible
+		blockSize := frame.Header.BlockSize
+		buf = buf[:0]
+		for i := 0; i < blockSize; i++ {
+			for _, channel := range frame.Samples {
+				sample := channel[i]
+				for b := 0; b < bytesPerSample; b++ {
+					buf = append(buf, byte(sample>>(8*b)))
+				}
+			}
+		}
+		hash.Write(buf)
+		check.Samples += uint64(blockSize)
+	}
+
+	copy(check.Actual[:], hash.Sum(nil))
+
+	if info.TotalSamples != 0 && check.Samples != info.TotalSamples {
+		return check, fmt.Errorf("flac: decoded %d samples, expected %d", check.Samples, info.TotalSamples)
+	}
+	return check, nil
+}
+
+// CheckAudioFile runs CheckAudio against the file at the given path
+func CheckAudioFile(filepath string) (*AudioCheck, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return CheckAudio(file)
+}
@@ -0,0 +1,170 @@
+package flac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCRC(t *testing.T) {
+	tests := []struct {
+		data  string
+		crc8  uint8
+		crc16 uint16
+	}{
+		{"", 0x00, 0x0000},
+		// the check values of CRC-8 (poly 0x07) and CRC-16/UMTS (poly 0x8005)
+		{"123456789", 0xf4, 0xfee8},
+		// the first frame header of the fixture, followed by this CRC-8
+		{"\xff\xf8\x19\x18\x00", 0xed, 0x19f4},
+	}
+
+	for _, test := range tests {
+		br := newBitReader(bytes.NewReader([]byte(test.data)))
+		for range test.data {
+			if _, err := br.read(8); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if br.crc8 != test.crc8 {
+			t.Errorf("CRC-8 of %q = %02x, expected %02x", test.data, br.crc8, test.crc8)
+		}
+		if br.crc16 != test.crc16 {
+			t.Errorf("CRC-16 of %q = %04x, expected %04x", test.data, br.crc16, test.crc16)
+		}
+	}
+}
+
+func TestBitReader(t *testing.T) {
+	data := []byte{
+		0b0001_1110, // unary 3, then 1110 is -2 as 4 signed bits
+		0b0000_0000, // unary 15 across two bytes
+		0b0000_0001,
+		0b1000_0000, // 33 signed bits, all ones except the first...
+		0, 0, 0,
+		0b0100_0010, // ...which is -2^32, then a 1 bit, unary 4 and a 0 bit
+	}
+	br := newBitReader(bytes.NewReader(data))
+
+	if n, err := br.readUnary(); err != nil || n != 3 {
+		t.Errorf("readUnary = %d, %v, expected 3", n, err)
+	}
+	if v, err := br.readSigned(4); err != nil || v != -2 {
+		t.Errorf("readSigned(4) = %d, %v, expected -2", v, err)
+	}
+	if n, err := br.readUnary(); err != nil || n != 15 {
+		t.Errorf("readUnary across bytes = %d, %v, expected 15", n, err)
+	}
+	if v, err := br.readSigned(33); err != nil || v != -1<<32 {
+		t.Errorf("readSigned(33) = %d, %v, expected %d", v, err, int64(-1<<32))
+	}
+	if v, err := br.read(1); err != nil || v != 1 {
+		t.Errorf("read(1) = %d, %v, expected 1", v, err)
+	}
+	if n, err := br.readUnary(); err != nil || n != 4 {
+		t.Errorf("readUnary = %d, %v, expected 4", n, err)
+	}
+	if _, err := br.readUnary(); err != io.ErrUnexpectedEOF {
+		t.Errorf("readUnary past the end = %v, expected io.ErrUnexpectedEOF", err)
+	}
+
+	br = newBitReader(bytes.NewReader([]byte{0b1010_1111, 0x7f}))
+	if v, _ := br.read(3); v != 0b101 {
+		t.Errorf("read(3) = %b, expected 101", v)
+	}
+	br.align()
+	if v, _ := br.readSigned(8); v != 127 {
+		t.Errorf("readSigned(8) after align = %d, expected 127", v)
+	}
+}
+
+// subframes.flac is made by testdata/make_subframes.py, which explains what is in each frame
+func readFixture(t *testing.T) []byte {
+	data, err := ioutil.ReadFile("testdata/subframes.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeSubframes(t *testing.T) {
+	dec, err := NewDecoder(bytes.NewReader(readFixture(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		blockSize  int
+		assignment uint8
+	}{
+		{192, 1},                // verbatim
+		{576, channelsLeftSide}, // fixed
+		{192, channelsMidSide},  // LPC
+		{37, channelsSideRight}, // LPC and constant
+	}
+
+	for i, w := range want {
+		frame, err := dec.Next()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		h := frame.Header
+		if h.BlockSize != w.blockSize || h.ChannelAssignment != w.assignment || h.Channels != 2 || h.SampleRate != 44100 || h.BitsPerSample != 16 {
+			t.Errorf("frame %d header = %+v, expected %d samples with channel assignment %d", i, h, w.blockSize, w.assignment)
+		}
+	}
+
+	if _, err := dec.Next(); err != io.EOF {
+		t.Errorf("after the last frame, Next = %v, expected io.EOF", err)
+	}
+}
+
+func TestCheckAudio(t *testing.T) {
+	check, err := CheckAudio(bytes.NewReader(readFixture(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.OK() {
+		t.Errorf("decoded audio is %x, expected %x (bad frames %v)", check.Actual, check.Expected, check.BadFrames)
+	}
+	if expected := "0c83065a5b614ad1995b47c410182004"; hex.EncodeToString(check.Expected[:]) != expected {
+		t.Errorf("STREAMINFO MD5 = %x, expected %s", check.Expected, expected)
+	}
+	if check.Samples != 997 {
+		t.Errorf("decoded %d samples, expected 997", check.Samples)
+	}
+}
+
+func TestCheckAudioFlippedByte(t *testing.T) {
+	data := readFixture(t)
+
+	// skip the metadata blocks to the first frame, whose
+	// subframes are verbatim so any byte can be flipped
+	offset := 4
+	for {
+		last := data[offset]&0x80 != 0
+		length := int(binary.BigEndian.Uint32(data[offset:]) & 0xffffff)
+		offset += 4 + length
+		if last {
+			break
+		}
+	}
+	if data[offset] != 0xff || data[offset+1] != 0xf8 {
+		t.Fatalf("expected the first frame at %d", offset)
+	}
+	data[offset+100] ^= 0x10
+
+	check, err := CheckAudio(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.OK() || check.Expected == check.Actual {
+		t.Error("expected the flipped byte to change the decoded audio")
+	}
+	if len(check.BadFrames) != 1 || check.BadFrames[0] != 0 {
+		t.Errorf("BadFrames = %v, expected [0]", check.BadFrames)
+	}
+}
@@ -0,0 +1,435 @@
+// Package flac reads the metadata of FLAC files without relying on metaflac.
+//
+// See https://xiph.org/flac/format.html for the format specification.
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// BlockType is the type of a metadata block
+type BlockType uint8
+
+const (
+	TypeStreamInfo    BlockType = 0
+	TypePadding       BlockType = 1
+	TypeApplication   BlockType = 2
+	TypeSeekTable     BlockType = 3
+	TypeVorbisComment BlockType = 4
+	TypeCueSheet      BlockType = 5
+	TypePicture       BlockType = 6
+)
+
+var ErrNotFLAC = errors.New("flac: missing fLaC stream marker")
+
+// StreamInfo holds the contents of the mandatory STREAMINFO block
+type StreamInfo struct {
+	MinBlockSize  uint16
+	MaxBlockSize  uint16
+	MinFrameSize  uint32
+	MaxFrameSize  uint32
+	SampleRate    uint32
+	Channels      uint8
+	BitsPerSample uint8
+	TotalSamples  uint64
+	MD5           [16]byte
+}
+
+// MD5String returns the audio signature the same way `metaflac --show-md5sum` does
+func (si StreamInfo) MD5String() string {
+	return fmt.Sprintf("%x", si.MD5)
+}
+
+// Tags holds the contents of a VORBIS_COMMENT block
+type Tags struct {
+	Vendor   string
+	Comments []string // raw "NAME=value" pairs in file order
+}
+
+// GetAll returns every value for the given field name, which is case insensitive
+func (t *Tags) GetAll(name string) (values []string) {
+	if t == nil {
+		return
+	}
+
+	prefix := strings.ToUpper(name) + "="
+	for _, comment := range t.Comments {
+		if len(comment) >= len(prefix) && strings.ToUpper(comment[:len(prefix)]) == prefix {
+			values = append(values, comment[len(prefix):])
+		}
+	}
+	return
+}
+
+// Get returns the first value for the given field name
+func (t *Tags) Get(name string) (string, bool) {
+	values := t.GetAll(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+type Picture struct {
+	Type        uint32
+	MIME        string
+	Description string
+	Width       uint32
+	Height      uint32
+	Depth       uint32
+	Colors      uint32
+	Data        []byte
+}
+
+type SeekPoint struct {
+	SampleNumber uint64
+	Offset       uint64
+	Samples      uint16
+}
+
+type CueSheet struct {
+	CatalogNumber string
+	LeadInSamples uint64
+	IsCD          bool
+	Tracks        []CueSheetTrack
+}
+
+type CueSheetTrack struct {
+	Offset      uint64
+	Number      uint8
+	ISRC        string
+	IsAudio     bool
+	PreEmphasis bool
+	Indices     []CueSheetIndex
+}
+
+type CueSheetIndex struct {
+	Offset uint64
+	Number uint8
+}
+
+type Application struct {
+	ID   [4]byte
+	Data []byte
+}
+
+// Metadata is every metadata block found at the start of a FLAC file
+type Metadata struct {
+	StreamInfo   StreamInfo
+	Tags         *Tags
+	Pictures     []Picture
+	SeekTable    []SeekPoint
+	CueSheet     *CueSheet
+	Applications []Application
+	Padding      int // total bytes of padding
+}
+
+// Seconds returns the length of the audio in whole seconds
+func (m *Metadata) Seconds() int64 {
+	if m.StreamInfo.SampleRate == 0 {
+		return 0
+	}
+	return int64(m.StreamInfo.TotalSamples / uint64(m.StreamInfo.SampleRate))
+}
+
+// ReadFile parses the metadata blocks of the FLAC file at the given path
+func ReadFile(filepath string) (*Metadata, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	meta, err := ReadMetadata(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath, err)
+	}
+	return meta, nil
+}
+
+// ReadMetadata parses the stream marker and every metadata block from r.
+// On success r is left positioned at the first audio frame.
+func ReadMetadata(r io.Reader) (*Metadata, error) {
+	if err := readMarker(r); err != nil {
+		return nil, err
+	}
+
+	meta := new(Metadata)
+	seenStreamInfo := false
+
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, fmt.Errorf("flac: reading block header: %w", unexpected(err))
+		}
+
+		last := header[0]&0x80 != 0
+		blockType := BlockType(header[0] & 0x7f)
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		if !seenStreamInfo && blockType != TypeStreamInfo {
+			return nil, errors.New("flac: first metadata block is not STREAMINFO")
+		}
+
+		if blockType == TypePadding {
+			// don't bother holding padding in memory
+			if _, err := io.CopyN(io.Discard, r, length); err != nil {
+				return nil, fmt.Errorf("flac: reading PADDING: %w", unexpected(err))
+			}
+			meta.Padding += int(length)
+		} else {
+			data := make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, fmt.Errorf("flac: reading block type %d: %w", blockType, unexpected(err))
+			}
+
+			if err := meta.parseBlock(blockType, data); err != nil {
+				return nil, err
+			}
+		}
+
+		seenStreamInfo = true
+		if last {
+			return meta, nil
+		}
+	}
+}
+
+// readMarker consumes the "fLaC" marker, skipping over an ID3v2 tag if there is one
+func readMarker(r io.Reader) error {
+	var marker [4]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil {
+		return ErrNotFLAC
+	}
+
+	if string(marker[:3]) == "ID3" {
+		// "ID3" and the major version are already read, then
+		// the revision, flags, and a 4 byte syncsafe size
+		var rest [6]byte
+		if _, err := io.ReadFull(r, rest[:]); err != nil {
+			return ErrNotFLAC
+		}
+		size := int64(rest[2])<<21 | int64(rest[3])<<14 | int64(rest[4])<<7 | int64(rest[5])
+
+		if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return ErrNotFLAC
+		}
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return ErrNotFLAC
+		}
+	}
+
+	if string(marker[:]) != "fLaC" {
+		return ErrNotFLAC
+	}
+	return nil
+}
+
+func (meta *Metadata) parseBlock(blockType BlockType, data []byte) (err error) {
+	switch blockType {
+	case TypeStreamInfo:
+		meta.StreamInfo, err = parseStreamInfo(data)
+	case TypeVorbisComment:
+		meta.Tags, err = parseVorbisComment(data)
+	case TypePicture:
+		var picture Picture
+		picture, err = parsePicture(data)
+		meta.Pictures = append(meta.Pictures, picture)
+	case TypeSeekTable:
+		meta.SeekTable, err = parseSeekTable(data)
+	case TypeCueSheet:
+		meta.CueSheet, err = parseCueSheet(data)
+	case TypeApplication:
+		if len(data) < 4 {
+			return errors.New("flac: APPLICATION block too short")
+		}
+		var app Application
+		copy(app.ID[:], data)
+		app.Data = data[4:]
+		meta.Applications = append(meta.Applications, app)
+	}
+	// unknown block types are reserved, so we just ignore them
+	return
+}
+
+func parseStreamInfo(data []byte) (si StreamInfo, err error) {
+	if len(data) != 34 {
+		return si, fmt.Errorf("flac: STREAMINFO has length %d, expected 34", len(data))
+	}
+
+	si.MinBlockSize = binary.BigEndian.Uint16(data[0:])
+	si.MaxBlockSize = binary.BigEndian.Uint16(data[2:])
+	si.MinFrameSize = uint32(data[4])<<16 | uint32(data[5])<<8 | uint32(data[6])
+	si.MaxFrameSize = uint32(data[7])<<16 | uint32(data[8])<<8 | uint32(data[9])
+
+	// 20 bits sample rate, 3 bits channels-1, 5 bits bps-1, 36 bits total samples
+	packed := binary.BigEndian.Uint64(data[10:])
+	si.SampleRate = uint32(packed >> 44)
+	si.Channels = uint8(packed>>41&0x7) + 1
+	si.BitsPerSample = uint8(packed>>36&0x1f) + 1
+	si.TotalSamples = packed & (1<<36 - 1)
+
+	copy(si.MD5[:], data[18:])
+	return
+}
+
+// vorbis comments are the only little endian part of the format
+func parseVorbisComment(data []byte) (*Tags, error) {
+	buf := bytes.NewReader(data)
+	tags := new(Tags)
+
+	readString := func() (string, error) {
+		var length uint32
+		if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
+			return "", err
+		}
+		if int64(length) > int64(buf.Len()) {
+			return "", io.ErrUnexpectedEOF
+		}
+		str := make([]byte, length)
+		_, err := io.ReadFull(buf, str)
+		return string(str), err
+	}
+
+	var err error
+	if tags.Vendor, err = readString(); err != nil {
+		return nil, fmt.Errorf("flac: reading VORBIS_COMMENT vendor: %w", unexpected(err))
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("flac: reading VORBIS_COMMENT count: %w", unexpected(err))
+	}
+
+	for i := uint32(0); i < count; i++ {
+		comment, err := readString()
+		if err != nil {
+			return nil, fmt.Errorf("flac: reading VORBIS_COMMENT %d: %w", i, unexpected(err))
+		}
+		tags.Comments = append(tags.Comments, comment)
+	}
+
+	return tags, nil
+}
+
+func parsePicture(data []byte) (p Picture, err error) {
+	buf := bytes.NewReader(data)
+
+	readString := func() ([]byte, error) {
+		var length uint32
+		if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		if int64(length) > int64(buf.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		str := make([]byte, length)
+		_, err := io.ReadFull(buf, str)
+		return str, err
+	}
+
+	var mime, desc []byte
+	var dimensions [4]uint32 // width, height, depth, colors
+
+	err = binary.Read(buf, binary.BigEndian, &p.Type)
+	if err == nil {
+		mime, err = readString()
+	}
+	if err == nil {
+		desc, err = readString()
+	}
+	if err == nil {
+		err = binary.Read(buf, binary.BigEndian, &dimensions)
+	}
+	if err == nil {
+		p.Data, err = readString()
+	}
+	if err != nil {
+		return p, fmt.Errorf("flac: reading PICTURE: %w", unexpected(err))
+	}
+
+	p.MIME = string(mime)
+	p.Description = string(desc)
+	p.Width, p.Height, p.Depth, p.Colors = dimensions[0], dimensions[1], dimensions[2], dimensions[3]
+	return
+}
+
+func parseSeekTable(data []byte) ([]SeekPoint, error) {
+	if len(data)%18 != 0 {
+		return nil, fmt.Errorf("flac: SEEKTABLE length %d is not a multiple of 18", len(data))
+	}
+
+	points := make([]SeekPoint, len(data)/18)
+	for i := range points {
+		point := data[i*18:]
+		points[i] = SeekPoint{
+			SampleNumber: binary.BigEndian.Uint64(point[0:]),
+			Offset:       binary.BigEndian.Uint64(point[8:]),
+			Samples:      binary.BigEndian.Uint16(point[16:]),
+		}
+	}
+	return points, nil
+}
+
+func parseCueSheet(data []byte) (*CueSheet, error) {
+	// catalog(128) + lead-in(8) + flags & reserved(259) + track count(1)
+	const headerLength = 128 + 8 + 259 + 1
+	if len(data) < headerLength {
+		return nil, errors.New("flac: CUESHEET block too short")
+	}
+
+	cue := &CueSheet{
+		CatalogNumber: string(bytes.TrimRight(data[:128], "\x00")),
+		LeadInSamples: binary.BigEndian.Uint64(data[128:]),
+		IsCD:          data[136]&0x80 != 0,
+	}
+	trackCount := int(data[headerLength-1])
+	data = data[headerLength:]
+
+	for i := 0; i < trackCount; i++ {
+		// offset(8) + number(1) + isrc(12) + flags & reserved(14) + index count(1)
+		const trackLength = 8 + 1 + 12 + 14 + 1
+		if len(data) < trackLength {
+			return nil, fmt.Errorf("flac: CUESHEET track %d truncated", i)
+		}
+
+		track := CueSheetTrack{
+			Offset:      binary.BigEndian.Uint64(data),
+			Number:      data[8],
+			ISRC:        string(bytes.TrimRight(data[9:21], "\x00")),
+			IsAudio:     data[21]&0x80 == 0,
+			PreEmphasis: data[21]&0x40 != 0,
+		}
+		indexCount := int(data[trackLength-1])
+		data = data[trackLength:]
+
+		if len(data) < indexCount*12 {
+			return nil, fmt.Errorf("flac: CUESHEET track %d indices truncated", i)
+		}
+		for j := 0; j < indexCount; j++ {
+			track.Indices = append(track.Indices, CueSheetIndex{
+				Offset: binary.BigEndian.Uint64(data),
+				Number: data[8],
+			})
+			data = data[12:]
+		}
+
+		cue.Tracks = append(cue.Tracks, track)
+	}
+
+	return cue, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,220 @@
+#!/usr/bin/env python3
+# Writes subframes.flac, a tiny 16-bit stereo file with one frame for each
+# stereo mode, and every kind of subframe in them. There is no flac encoder
+# that lets you choose subframes, so this is a (very) small one.
+#
+#   frame 0: independent, 192 samples, verbatim and verbatim
+#   frame 1: left/side, 576 samples, fixed order 2 and fixed order 1
+#            (rice partition order 2, one escaped partition)
+#   frame 2: mid/side, 192 samples, LPC order 8 and LPC order 2
+#   frame 3: side/right, 37 samples, LPC order 1 and constant
+#
+# Run it from this folder: python3 make_subframes.py
+import hashlib
+import math
+import random
+import struct
+
+RATE = 44100
+BPS = 16
+
+
+class BitWriter:
+    def __init__(self):
+        self.bits = []
+
+    def write(self, value, n):
+        for i in range(n - 1, -1, -1):
+            self.bits.append((value >> i) & 1)
+
+    def write_signed(self, value, n):
+        self.write(value & ((1 << n) - 1), n)
+
+    def unary(self, q):
+        self.bits.extend([0] * q)
+        self.bits.append(1)
+
+    def bytes(self):
+        while len(self.bits) % 8:
+            self.bits.append(0)
+        out = bytearray()
+        for i in range(0, len(self.bits), 8):
+            b = 0
+            for bit in self.bits[i:i + 8]:
+                b = b << 1 | bit
+            out.append(b)
+        return bytes(out)
+
+
+def crc8(data):
+    c = 0
+    for b in data:
+        c ^= b
+        for _ in range(8):
+            c = ((c << 1) ^ 0x07) & 0xff if c & 0x80 else (c << 1) & 0xff
+    return c
+
+
+def crc16(data):
+    c = 0
+    for b in data:
+        c ^= b << 8
+        for _ in range(8):
+            c = ((c << 1) ^ 0x8005) & 0xffff if c & 0x8000 else (c << 1) & 0xffff
+    return c
+
+
+def rice(bw, residual, order, partition_order, escaped=()):
+    bw.write(0, 2)  # 4-bit rice parameters
+    bw.write(partition_order, 4)
+    size = (len(residual) + order) >> partition_order
+    start = 0
+    for p in range(1 << partition_order):
+        n = size - order if p == 0 else size
+        chunk = residual[start:start + n]
+        start += n
+
+        if p in escaped:
+            bits = max(abs(x) for x in chunk).bit_length() + 1
+            bw.write(15, 4)
+            bw.write(bits, 5)
+            for x in chunk:
+                bw.write_signed(x, bits)
+            continue
+
+        mean = sum(abs(x) for x in chunk) / len(chunk)
+        k = max(0, min(14, int(math.log2(mean + 1))))
+        bw.write(k, 4)
+        for x in chunk:
+            u = x << 1 if x >= 0 else ((-x) << 1) - 1
+            bw.unary(u >> k)
+            if k:
+                bw.write(u & ((1 << k) - 1), k)
+
+
+def verbatim(bw, samples, bps):
+    bw.write(1 << 1, 8)
+    for x in samples:
+        bw.write_signed(x, bps)
+
+
+def constant(bw, samples, bps):
+    assert all(x == samples[0] for x in samples)
+    bw.write(0, 8)
+    bw.write_signed(samples[0], bps)
+
+
+FIXED = [[], [1], [2, -1], [3, -3, 1], [4, -6, 4, -1]]
+
+
+def fixed(bw, samples, bps, order, partition_order=0, escaped=()):
+    bw.write((8 | order) << 1, 8)
+    for x in samples[:order]:
+        bw.write_signed(x, bps)
+    c = FIXED[order]
+    residual = [samples[i] - sum(c[j] * samples[i - 1 - j] for j in range(order))
+                for i in range(order, len(samples))]
+    rice(bw, residual, order, partition_order, escaped)
+
+
+def lpc(bw, samples, bps, coefficients, precision, shift):
+    order = len(coefficients)
+    bw.write((0x20 | (order - 1)) << 1, 8)
+    for x in samples[:order]:
+        bw.write_signed(x, bps)
+    bw.write(precision - 1, 4)
+    bw.write_signed(shift, 5)
+    for c in coefficients:
+        bw.write_signed(c, precision)
+    residual = [samples[i] - (sum(coefficients[j] * samples[i - 1 - j] for j in range(order)) >> shift)
+                for i in range(order, len(samples))]
+    rice(bw, residual, order, 0)
+
+
+def frame(number, block_size, block_code, assignment, subframes, tail=b""):
+    h = BitWriter()
+    h.write(0x3ffe, 14)
+    h.write(0, 2)
+    h.write(block_code, 4)
+    h.write(9, 4)  # 44.1kHz
+    h.write(assignment, 4)
+    h.write(4, 3)  # 16 bits per sample
+    h.write(0, 1)
+    header = h.bytes() + bytes([number]) + tail
+    header += bytes([crc8(header)])
+
+    bw = BitWriter()
+    for write in subframes:
+        write(bw)
+    data = header + bw.bytes()
+    return data + struct.pack(">H", crc16(data))
+
+
+def main():
+    rng = random.Random(2)
+    sizes = [192, 576, 192, 37]
+    total = sum(sizes)
+
+    left = [int(9000 * math.sin(i * 0.05)) + rng.randint(-40, 40) for i in range(total)]
+    right = [int(7000 * math.sin(i * 0.031 + 1)) + rng.randint(-40, 40) for i in range(total)]
+    # the right channel is silent-ish in the last frame, for a constant subframe
+    for i in range(total - sizes[-1], total):
+        right[i] = 100
+
+    def block(n):
+        start = sum(sizes[:n])
+        return left[start:start + sizes[n]], right[start:start + sizes[n]]
+
+    frames = b""
+
+    l, r = block(0)
+    frames += frame(0, 192, 1, 1, [
+        lambda bw: verbatim(bw, l, BPS),
+        lambda bw: verbatim(bw, r, BPS),
+    ])
+
+    l1, r1 = block(1)
+    side1 = [a - b for a, b in zip(l1, r1)]
+    frames += frame(1, 576, 2, 8, [
+        lambda bw: fixed(bw, l1, BPS, 2, partition_order=2, escaped=(1,)),
+        lambda bw: fixed(bw, side1, BPS + 1, 1),
+    ])
+
+    l2, r2 = block(2)
+    mid2 = [(a + b) >> 1 for a, b in zip(l2, r2)]
+    side2 = [a - b for a, b in zip(l2, r2)]
+    frames += frame(2, 192, 1, 10, [
+        lambda bw: lpc(bw, mid2, BPS, [1800, -900, 40, -30, 20, -10, 5, -2], 13, 10),
+        lambda bw: lpc(bw, side2, BPS + 1, [1946, -973], 12, 10),
+    ])
+
+    l3, r3 = block(3)
+    side3 = [a - b for a, b in zip(l3, r3)]
+    frames += frame(3, 37, 6, 9, [
+        lambda bw: lpc(bw, side3, BPS + 1, [15], 5, 4),
+        lambda bw: constant(bw, r3, BPS),
+    ], tail=bytes([37 - 1]))
+
+    md5 = hashlib.md5()
+    for a, b in zip(left, right):
+        md5.update(a.to_bytes(2, "little", signed=True))
+        md5.update(b.to_bytes(2, "little", signed=True))
+
+    streaminfo = struct.pack(">HH", min(sizes), max(sizes)) + bytes(6)
+    streaminfo += struct.pack(">Q", RATE << 44 | (2 - 1) << 41 | (BPS - 1) << 36 | total)
+    streaminfo += md5.digest()
+
+    vendor = b"make_subframes.py"
+    comment = struct.pack("<I", len(vendor)) + vendor + struct.pack("<I", 0)
+
+    out = b"fLaC"
+    out += bytes([0]) + struct.pack(">I", len(streaminfo))[1:] + streaminfo
+    out += bytes([0x80 | 4]) + struct.pack(">I", len(comment))[1:] + comment
+    out += frames
+
+    with open("subframes.flac", "wb") as f:
+        f.write(out)
+    print(md5.hexdigest(), total, "samples")
+
+
+main()
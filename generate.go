@@ -1,80 +1,59 @@
 package main
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"os"
-	"os/exec"
+	"io/ioutil"
+	"path"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/qaisjp/dmlivewiki/flac"
 	"github.com/qaisjp/dmlivewiki/util"
 )
 
-type Tour struct {
-	Name   string
-	Tracks map[string]struct{}
-}
-
 type AlbumData struct {
 	Artist   string
 	Date     string
 	Album    string
 	Tour     string
+	Source   int // this recording is Source 1, 2... for its date
 	Tracks   []TrackData
 	Duration string
+	Config   *Config
+
+	Annotations []Annotation // used by any track, for the footer
 }
 
 type TrackData struct {
-	Title                    string
-	Duration                 string
-	HasAlternateLeadVocalist bool
-	Prefix                   string
-	Index                    int
+	Title       string
+	Duration    string
+	Annotations []Annotation
+	Prefix      string
+	Index       int
 }
 
-func getTourFromTourFile(filepath string, tour *Tour) error {
-	file, err := os.Open(filepath)
-	if err != nil {
-		return errors.New("Could not open Tourfile (" + err.Error() + ")")
-	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
-	scanner := bufio.NewScanner(reader)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		prefix := tour.Name + ":"
-		if !strings.HasPrefix(line, prefix) {
-			continue
-		}
-
-		list := strings.TrimSpace(strings.TrimPrefix(line, prefix))
-		tracks := strings.Split(list, ",")
-		tour.Tracks = make(map[string]struct{})
-
-		for _, track := range tracks {
-			tour.Tracks[strings.TrimSpace(track)] = struct{}{}
-		}
-		return nil
-	}
-	return errors.New("Tourfile does not contain tour")
+// skippedFile is a flac file that could not be used by generate
+type skippedFile struct {
+	Path   string
+	Reason string
+	Action string // "album skipped" or "track omitted"
 }
 
 // tags: http://age.hobba.nl/audio/tag_frame_reference.html
-func getTagsFromFile(filepath string, album *AlbumData, albumDuration *time.Duration) TrackData {
-	args := []string{
-		"--show-total-samples",
-		"--show-sample-rate",
+// album is only updated if every tag could be read
+func getTagsFromFile(filepath string, album *AlbumData, albumDuration *time.Duration) (TrackData, error) {
+	var track TrackData
+
+	meta, err := flac.ReadFile(filepath)
+	if err != nil {
+		return track, fmt.Errorf("could not read flac metadata from %s (%s)", filepath, err.Error())
 	}
 
-	nonTagArgs := len(args)
 	tags := []string{"title", "tracknumber"}
 
 	getAlbumData := album.Artist == ""
+	albumData := *album
 	if getAlbumData {
 		tags = append(tags,
 			"artist",
@@ -83,83 +62,93 @@ func getTagsFromFile(filepath string, album *AlbumData, albumDuration *time.Dura
 		)
 	}
 
-	for _, tag := range tags {
-		args = append(args, "--show-tag="+tag)
-	}
-	args = append(args, filepath)
+	for _, tagName := range tags {
+		tagValue, ok := meta.Tags.Get(tagName)
+		if !ok {
+			return track, fmt.Errorf("expected tag %s in %s", tagName, filepath)
+		}
 
-	data, err := exec.Command(
-		metaflacPath,
-		args[:]...,
-	).Output()
+		switch tagName {
+		case "title":
+			track.Title = tagValue
+		case "tracknumber":
+			num, err := strconv.Atoi(tagValue)
+			if err != nil {
+				return track, fmt.Errorf("tracknumber %q in %s is not a number", tagValue, filepath)
+			}
 
-	if err != nil {
-		fmt.Println("metaflac returned an invalid response")
-		fmt.Println("[DEBUG] len(album.Tracks) == ", len(album.Tracks))
-		if data != nil {
-			fmt.Println(data)
+			track.Index = num
+		case "artist":
+			albumData.Artist = tagValue
+		case "date":
+			albumData.Date = tagValue
+		case "album":
+			// album tags are prefixed with the date, "YYYY-MM-DD "
+			if len(tagValue) < 11 {
+				return track, fmt.Errorf("album tag %q in %s is missing the date prefix", tagValue, filepath)
+			}
+			albumData.Album = tagValue[11:]
 		}
-		panic(err)
 	}
 
-	var track TrackData
+	*album = albumData
+
+	duration := time.Duration(meta.Seconds()) * time.Second
+	*albumDuration += duration
+	track.Duration = util.FormatDuration(duration)
+
+	return track, nil
+}
 
-	lines := strings.Split(string(data), "\n")
-	if len(lines) != len(args) {
-		panic(fmt.Sprintf("[invalid metaflac output] Expected %d lines, got %d", len(args), len(lines)))
-		// todo, return a bool to delete this file
-		// and say that the current file is being skipped
-		// perhaps an --ignore flag to enable this feature
-		// false by default, to make it cancel the whole procedure?
+// getAlbumDate reads the date tag of the first flac file in an album
+func getAlbumDate(directory string) (string, error) {
+	tags, err := getAlbumTags(directory, "date")
+	if err != nil {
+		return "", err
 	}
+	return tags[0], nil
+}
 
-	var samples, sampleRate int64
-	for i, line := range lines {
-		line = strings.TrimSpace(line)
+// getAlbumTags reads tags from the first flac file in an album
+func getAlbumTags(directory string, names ...string) ([]string, error) {
+	contents, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
 
-		switch {
-		case i <= 1:
-			value, err := strconv.Atoi(line)
-			if err != nil {
-				panic(err)
+	var folders []string
+	for _, file := range contents {
+		name := file.Name()
+		if file.IsDir() {
+			if isCDFolder(name) {
+				folders = append(folders, name)
 			}
+			continue
+		} else if path.Ext(name) != ".flac" {
+			continue
+		}
 
-			if i == 0 {
-				samples = int64(value)
-			} else {
-				sampleRate = int64(value)
-			}
-		case i < len(args)-1:
-			tagName := tags[i-nonTagArgs]
-			prefix := tagName + "="
+		meta, err := flac.ReadFile(path.Join(directory, name))
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s (%s)", name, err.Error())
+		}
 
-			if strings.LastIndex(strings.ToLower(line), prefix) == -1 {
-				panic(fmt.Sprintf("Expected prefix %s, but line is: %s", prefix, strings.ToLower(line)))
-			}
-			tagValue := line[len(tagName)+1:]
-
-			switch tagName {
-			case "title":
-				track.Title = tagValue
-			case "tracknumber":
-				num, err := strconv.Atoi(tagValue)
-				if err != nil {
-					panic(err)
-				}
-
-				track.Index = num
-			case "artist":
-				album.Artist = tagValue
-			case "date":
-				album.Date = tagValue
-			case "album":
-				album.Album = tagValue[11:]
+		tags := make([]string, len(names))
+		for i, tagName := range names {
+			value, ok := meta.Tags.Get(tagName)
+			if !ok {
+				return nil, fmt.Errorf("expected tag %s in %s", tagName, name)
 			}
+			tags[i] = value
 		}
+		return tags, nil
 	}
-	duration := time.Duration(samples/sampleRate) * time.Second
-	*albumDuration += duration
-	track.Duration = util.FormatDuration(duration)
 
-	return track
+	// Albums split into CDs only have flac files in the CD folders
+	for _, folder := range folders {
+		if tags, err := getAlbumTags(path.Join(directory, folder), names...); err == nil {
+			return tags, nil
+		}
+	}
+	return nil, errors.New("no flac files found")
 }
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
 	"strings"
+	"text/tabwriter"
 	"text/template"
 	"time"
 
@@ -12,18 +16,16 @@ import (
 	"gopkg.in/urfave/cli.v1"
 )
 
-func generateInformation(c *cli.Context) {
-	fileInfo, filepath := util.CheckFilepathArgument(c)
-	if fileInfo == nil {
-		return
+func generateInformation(c *cli.Context) error {
+	fileInfo, filepath, err := util.CheckFilepathArgument(c)
+	if err != nil {
+		return usageError{err}
 	}
 
 	tourName := c.String("tour")
-	if tourName == "" {
-		if err := cli.ShowSubcommandHelp(c); err != nil {
-			panic(err)
-		}
-		return
+	tourfile := c.String("tour-file")
+	if tourName == "" && tourfile == "" {
+		return newUsageError(c, "--tour is required, unless tours can be worked out from the dates in a --tour-file")
 	}
 
 	mode := "batch"
@@ -31,73 +33,290 @@ func generateInformation(c *cli.Context) {
 		mode = "single"
 	}
 
-	tourfile := c.String("tour-file")
+	var db *TourDatabase
 	if tourfile != "" {
-		fileInfo, tourfileClean := util.GetFileOfType(tourfile, false, "tour-file")
-		if fileInfo == nil {
-			return
+		_, tourfileClean, err := util.GetFileOfType(tourfile, false, "tour-file")
+		if err != nil {
+			return usageError{err}
 		}
 		tourfile = tourfileClean
 		fmt.Println("Processing tours from:", tourfile)
+
+		db, err = loadTourDatabase(tourfile)
+		if err != nil {
+			return usageError{err}
+		}
+	}
+
+	// fallback is the tour to use when it can't be worked out from the date
+	var fallback *Tour
+	if tourName != "" {
+		fallback = &Tour{Name: tourName}
+		if db != nil {
+			found, ok := db.find(tourName)
+			if !ok {
+				return newUsageError(c, "%s doesn't contain the tour %q", tourfile, tourName)
+			}
+			fallback = found
+		}
+	}
+
+	found, err := findAlbums(c, filepath, fileInfo, os.Stdout)
+	if err != nil {
+		return err
+	}
+	var albums []*generateAlbum
+	for _, album := range found {
+		albums = append(albums, &generateAlbum{album: album})
+	}
+
+	deleteMode := c.GlobalBool("delete")
+	if db != nil && db.hasDates() && !deleteMode {
+		for _, album := range albums {
+			album.findTour(db, fallback)
+		}
+		generatePrintTours(albums)
+	} else if fallback == nil && !deleteMode {
+		return newUsageError(c, "--tour is required, as the tour file doesn't have any dates")
+	} else {
+		fmt.Println("The current tour is:", tourName)
+		for _, album := range albums {
+			album.tour = fallback
+		}
+	}
+
+	if !deleteMode {
+		generateNumberSources(albums)
 	}
 
-	fmt.Println("The current tour is:", tourName)
 	fmt.Printf("The following filepath (%s mode) will be processed: %s\n", mode, filepath)
+	printAlbumCount(os.Stdout, found)
 	util.NotifyDeleteMode(c)
 
 	if !util.ShouldContinue(c) {
-		return
+		return nil
 	}
 
-	tour := new(Tour)
-	tour.Name = tourName
-	if tourfile != "" { // tourFile is only for reading "alternate vocalists" into tracks map
-		if err := getTourFromTourFile(tourfile, tour); err != nil {
-			fmt.Println("[Error]", err)
-			if !util.ShouldContinue(c) {
-				return
-			}
+	t, err := loadInformationTemplate()
+	if err != nil {
+		return usageError{err}
+	}
+
+	options := generateOptions{
+		template:        t,
+		out:             newOutput(c),
+		deleteMode:      deleteMode,
+		ignoreBadTracks: c.Bool("ignore-bad-tracks"),
+		merge:           c.Bool("merge"),
+	}
+
+	batch := newBatchErrors()
+	var skipped []skippedFile
+	for _, album := range albums {
+		if album.tour == nil && !deleteMode {
+			fmt.Printf("Skipping! Could not work out the tour for %s (%s)\n", album.path, album.reason)
+			batch.add(album.rel, fmt.Errorf("could not work out the tour (%s)", album.reason))
+			continue
 		}
+
+		options.tour = album.tour
+		options.source = album.source
+		batch.add(album.rel, generateFile(album.path, album.name, options, &skipped))
 	}
 
-	// Stupid windows
-	informationTemplate = strings.Replace(informationTemplate, "\n", "\r\n", -1)
+	generatePrintSkipped(skipped)
+	return batch.err()
+}
+
+// generateAlbum is an album to generate an information file for, and the tour it is from
+type generateAlbum struct {
+	album
+	date   string
+	tour   *Tour
+	reason string // how the tour was chosen, or why it couldn't be
+
+	source       int
+	sourceReason string // how the source was chosen
+}
+
+// findTour works out the tour from the date of the album,
+// using the fallback if there isn't exactly one match
+func (a *generateAlbum) findTour(db *TourDatabase, fallback *Tour) {
+	var tours []*Tour
+	date, err := getAlbumDate(a.path)
+	if err == nil {
+		a.date = date
+		tours, err = db.toursOn(date)
+	}
 
-	if mode == "single" {
-		generateFile(filepath, fileInfo.Name(), *tour, c.GlobalBool("delete"))
+	switch {
+	case err != nil:
+		a.reason = err.Error()
+	case len(tours) == 1:
+		a.tour = tours[0]
+		a.reason = "from the date"
 		return
+	case len(tours) == 0:
+		a.reason = "no tours on this date"
+	default:
+		names := make([]string, len(tours))
+		for i, tour := range tours {
+			names[i] = tour.Name
+		}
+		a.reason = "the date matches " + strings.Join(names, ", ")
+	}
+
+	if fallback != nil {
+		a.tour = fallback
+		a.reason = "from --tour, " + a.reason
+	} else {
+		a.reason += ", use --tour to choose one"
 	}
+}
 
-	files, _ := ioutil.ReadDir(filepath)
-	for _, file := range files {
-		if file.IsDir() && (file.Name() != "__wikifiles") {
-			name := file.Name()
-			generateFile(path.Join(filepath, name), name, *tour, c.GlobalBool("delete"))
+// generatePrintTours shows which tour every album is from
+func generatePrintTours(albums []*generateAlbum) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Album\tDate\tTour\t")
+	for _, album := range albums {
+		date := album.date
+		if date == "" {
+			date = "?"
 		}
+		tour := "?"
+		if album.tour != nil {
+			tour = album.tour.Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t(%s)\n", album.rel, date, tour, album.reason)
 	}
+	w.Flush()
+	fmt.Println()
 }
 
-func generateFile(filepath string, name string, tour Tour, deleteMode bool) {
-	outputFilename := path.Join(filepath, name+".txt")
-	if deleteMode {
-		util.RemoveFile(outputFilename, true)
+// Tell the user about every bad flac file once everything has been processed
+func generatePrintSkipped(skipped []skippedFile) {
+	if len(skipped) == 0 {
 		return
 	}
 
+	fmt.Printf("\nSkipped %d bad file(s):\n", len(skipped))
+	for _, file := range skipped {
+		fmt.Printf(" - %s (%s): %s\n", file.Path, file.Action, file.Reason)
+	}
+}
+
+type generateOptions struct {
+	tour            *Tour
+	source          int
+	template        *template.Template
+	out             *output
+	deleteMode      bool
+	ignoreBadTracks bool
+	merge           bool // keep the Lineage and Notes of an existing file
+}
+
+func generateFile(filepath string, name string, options generateOptions, skipped *[]skippedFile) error {
+	outputFilename := path.Join(filepath, name+".txt")
+	if options.deleteMode {
+		options.out.removeFile(outputFilename, true)
+		return nil
+	}
+
 	album := new(AlbumData)
-	album.Tour = tour.Name
+	album.Config = &config
+	album.Tour = options.tour.Name
+	album.Source = options.source
+
+	iterating, err := generateListAlbum(filepath)
+	if err != nil {
+		return err
+	}
+
+	albumDuration := time.Duration(0) // duration incrementer for the album
+	var badTracks []skippedFile
+	for _, file := range iterating {
+		track, err := getTagsFromFile(path.Join(filepath, file), album, &albumDuration)
+		if err != nil {
+			fmt.Println("Bad track!", err.Error())
+			badTracks = append(badTracks, skippedFile{
+				Path:   path.Join(filepath, file),
+				Reason: err.Error(),
+				Action: "track omitted",
+			})
+			continue
+		}
 
+		track.Annotations = options.tour.annotate(track.Title, album.Date)
+
+		track.Prefix = generateTrackPrefix(file)
+
+		// Finally, add the new track to the album
+		album.Tracks = append(album.Tracks, track)
+	}
+
+	if len(badTracks) > 0 && !options.ignoreBadTracks {
+		for i := range badTracks {
+			badTracks[i].Action = "album skipped"
+		}
+		*skipped = append(*skipped, badTracks...)
+
+		fmt.Printf("Skipping! %d bad track(s) in %s (use --ignore-bad-tracks to leave them out)\n", len(badTracks), filepath)
+		return fmt.Errorf("%d bad track(s)", len(badTracks))
+	}
+	*skipped = append(*skipped, badTracks...)
+
+	if len(album.Tracks) == 0 {
+		fmt.Println("Could not create album - aborting creation of", outputFilename)
+		return errors.New("no tracks found")
+	}
+
+	album.Duration = util.FormatDuration(albumDuration)
+
+	for _, track := range album.Tracks {
+		album.Annotations = usedAnnotations(album.Annotations, track.Annotations)
+	}
+
+	var info bytes.Buffer
+	if err := options.template.Execute(&info, album); err != nil {
+		return fmt.Errorf("could not write %s (%s)", outputFilename, err.Error())
+	}
+
+	data := []byte(windowsLineEndings(info.String()))
+	if options.merge {
+		merged, err := generateMerge(outputFilename, data)
+		if err != nil {
+			fmt.Println("Skipping! Could not merge with", outputFilename+":", err.Error())
+			return fmt.Errorf("could not merge (%s)", err.Error())
+		}
+		data = merged
+	}
+
+	fmt.Println("Creating", outputFilename+"...")
+	if _, err := options.out.writeFile(outputFilename, data); err != nil {
+		fmt.Println("Could not create file:", err.Error())
+		return err
+	}
+	return nil
+}
+
+// generateListAlbum lists the flac files of an album, relative to it. If there are
+// CD folders, like "CD1", only the files in them are used. Any other folder with
+// flac files in it, like "Bonus" or "CD Art", is a disc named after the folder.
+func generateListAlbum(filepath string) ([]string, error) {
 	var useCDNames bool
 	var folders []string
 	var extraFolders []string
 	var files []string
 
-	directoryContents, _ := ioutil.ReadDir(filepath)
+	directoryContents, err := ioutil.ReadDir(filepath)
+	if err != nil {
+		return nil, err
+	}
 	for _, fileinfo := range directoryContents {
 		filename := fileinfo.Name()
 		isDir := fileinfo.IsDir()
 		if isDir {
-			if strings.HasPrefix(filename, "CD") {
+			if isCDFolder(filename) {
 				folders = append(folders, filename)
 				useCDNames = true
 			} else {
@@ -117,70 +336,86 @@ func generateFile(filepath string, name string, tour Tour, deleteMode bool) {
 			fmt.Println("Warning! Files outside CD folders in", filepath)
 		}
 
-		var files []string
-		var subfolders []string
+		iterating = nil // this means old files won't be iterated
 		for _, dirName := range folders {
-			subdirectory, _ := ioutil.ReadDir(path.Join(filepath, dirName))
-			for _, fileinfo := range subdirectory {
-				subdirPath := path.Join(dirName, fileinfo.Name())
-				if isDir := fileinfo.IsDir(); isDir {
-					subfolders = append(subfolders, subdirPath)
-				} else if (path.Ext(fileinfo.Name()) == ".flac") && !isDir {
-					files = append(files, subdirPath)
-				}
+			files, hasSubfolders, err := generateListDisc(filepath, dirName)
+			if err != nil {
+				return nil, err
+			} else if hasSubfolders {
+				fmt.Printf("Skipping! CD folders can't have folders in them (%s)\n", path.Join(filepath, dirName))
+				return nil, fmt.Errorf("%s has folders in it", dirName)
 			}
+			iterating = append(iterating, files...)
 		}
+	}
 
-		if len(subfolders) > 0 {
-			fmt.Printf("Skipping! Filepath has depth=3 folders (%s)\n", filepath)
-			return
+	// Other folders with flac files, like "Bonus" or "Soundcheck",
+	// are treated as discs named after the folder, after everything else
+	for _, dirName := range extraFolders {
+		files, hasSubfolders, err := generateListDisc(filepath, dirName)
+		if err != nil {
+			return nil, err
+		} else if len(files) == 0 {
+			continue
+		} else if hasSubfolders {
+			fmt.Printf("Skipping! Disc folders can't have folders in them (%s)\n", path.Join(filepath, dirName))
+			return nil, fmt.Errorf("%s has folders in it", dirName)
+		} else if strings.ContainsAny(dirName, ".[]") {
+			fmt.Printf("Skipping! Folder names can't contain \".\", \"[\" or \"]\" (%s)\n", path.Join(filepath, dirName))
+			return nil, fmt.Errorf("bad folder name %q", dirName)
 		}
-
-		iterating = files // set it to the new files
-		// this means old files won't be iterated
+		iterating = append(iterating, files...)
 	}
 
-	if len(extraFolders) > 0 {
-		// Contains extra folders, do something!
-		// There's probably a folder like "Bonus"
-		fmt.Println("Warning! Extra non CD folders inside", filepath)
-	}
+	return iterating, nil
+}
 
-	albumDuration := time.Duration(0) // duration incrementer for the album
-	for _, file := range iterating {
-		track := getTagsFromFile(path.Join(filepath, file), album, &albumDuration)
+// generateTrackPrefix is the prefix of a track in the information file:
+// "CD1/01.flac" is "1.01." and "Bonus/01.flac" is "Bonus.01."
+func generateTrackPrefix(file string) string {
+	dir := path.Dir(file)
+	if dir == "." {
+		return ""
+	}
+	if isCDFolder(dir) {
+		dir = strings.TrimPrefix(dir, "CD")
+	}
+	return dir + "."
+}
 
-		if tour.Tracks != nil {
-			_, containsAlternateLeadVocalist := tour.Tracks[track.Title]
-			track.HasAlternateLeadVocalist = containsAlternateLeadVocalist
-		}
+// generateListDisc lists the flac files in a folder of an album
+func generateListDisc(filepath string, dirName string) (files []string, hasSubfolders bool, err error) {
+	contents, err := ioutil.ReadDir(path.Join(filepath, dirName))
+	if err != nil {
+		return nil, false, err
+	}
 
-		if useCDNames {
-			track.Prefix = strings.TrimPrefix(path.Dir(file), "CD") + "."
+	for _, fileinfo := range contents {
+		if fileinfo.IsDir() {
+			hasSubfolders = true
+		} else if path.Ext(fileinfo.Name()) == ".flac" {
+			files = append(files, path.Join(dirName, fileinfo.Name()))
 		}
-
-		// Finally, add the new track to the album
-		album.Tracks = append(album.Tracks, track)
 	}
+	return
+}
 
-	if len(album.Tracks) == 0 {
-		fmt.Println("Could not create album - aborting creation of", outputFilename)
-		return
+// generateMerge keeps the hand written parts of an existing
+// information file, refreshing everything else from the tags
+func generateMerge(filename string, generated []byte) ([]byte, error) {
+	old, err := readInfoFile(filename)
+	if os.IsNotExist(err) {
+		// nothing to keep
+		return generated, nil
+	} else if err != nil {
+		return nil, err
 	}
 
-	album.Duration = util.FormatDuration(albumDuration)
-
-	funcMap := template.FuncMap{"wikiescape": util.WikiEscape}
-	t := template.Must(template.New("generate").Funcs(funcMap).Parse(informationTemplate))
-
-	fmt.Println("Creating", outputFilename+"...")
-	infoFile := util.CreateFile(outputFilename)
-	defer infoFile.Close()
-
-	if infoFile != nil {
-		err := t.Execute(infoFile, album)
-		if err != nil {
-			panic(err)
-		}
+	info, err := parseInfoFile(bytes.NewReader(generated))
+	if err != nil {
+		return nil, fmt.Errorf("the information template can't be merged into (%s)", err.Error())
 	}
+
+	info.merge(old)
+	return info.Bytes(), nil
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	fpath "path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateListAlbum(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "flat",
+			files: []string{"01.flac", "02.flac", "cover.jpg"},
+			want:  []string{"01.flac", "02.flac"},
+		},
+		{
+			name:  "CD folders",
+			files: []string{"CD2/01.flac", "CD1/01.flac", "CD1/02.flac"},
+			want:  []string{"CD1/01.flac", "CD1/02.flac", "CD2/01.flac"},
+		},
+		{
+			name:  "CD folders and a bonus disc",
+			files: []string{"CD1/01.flac", "Bonus/01.flac", "Artwork/front.jpg"},
+			want:  []string{"CD1/01.flac", "Bonus/01.flac"},
+		},
+		{
+			// "CD Art" isn't a CD, so the files next to it are still used
+			name:  "folder starting with CD",
+			files: []string{"01.flac", "02.flac", "CD Art/front.jpg"},
+			want:  []string{"01.flac", "02.flac"},
+		},
+		{
+			name:  "named disc starting with CD",
+			files: []string{"01.flac", "CDBonus/01.flac"},
+			want:  []string{"01.flac", "CDBonus/01.flac"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, file := range test.files {
+				filename := fpath.Join(dir, fpath.FromSlash(file))
+				if err := os.MkdirAll(fpath.Dir(filename), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filename, nil, 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := generateListAlbum(dir)
+			if err != nil {
+				t.Fatalf("generateListAlbum: %v", err)
+			}
+			for i := range got {
+				got[i] = fpath.ToSlash(got[i])
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("generateListAlbum = %q, expected %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTrackPrefix(t *testing.T) {
+	tests := map[string]string{
+		"01.flac":         "",
+		"CD1/01.flac":     "1.",
+		"CD12/01.flac":    "12.",
+		"Bonus/01.flac":   "Bonus.",
+		"CDBonus/01.flac": "CDBonus.",
+		"CD Art/01.flac":  "CD Art.",
+	}
+
+	for file, want := range tests {
+		if got := generateTrackPrefix(file); got != want {
+			t.Errorf("generateTrackPrefix(%q) = %q, expected %q", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	fpath "path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// "1990-05-02 Somewhere/Source 2", the title of the page of a source
+var sourceTitleRegex = regexp.MustCompile(`^(.*)/Source (\d+)$`)
+
+// generateNumberSources works out which source of its date each album is, so that two recordings
+// of the same show (the same date and album tags) get their own page. Numbers come from, in order:
+// the sources config field, the album's existing information file, the page that was published from
+// the album, and then the lowest number that isn't already taken by another album in the batch or
+// by a page that was published to the wiki from an album that isn't in the batch.
+func generateNumberSources(albums []*generateAlbum) {
+	published, err := loadPublishedRevisions()
+	if err != nil {
+		fmt.Println("Can't tell which sources are already on the wiki:", err.Error())
+	}
+
+	shows := make(map[string][]*generateAlbum)
+	var keys []string
+	for _, album := range albums {
+		album.source = 1
+
+		tags, err := getAlbumTags(album.path, "date", "album")
+		if err != nil {
+			album.sourceReason = err.Error()
+			continue
+		} else if len(tags[1]) < 11 {
+			album.sourceReason = fmt.Sprintf("album tag %q is missing the date prefix", tags[1])
+			continue
+		}
+
+		// the page title without the source, like "1990-05-02 Somewhere"
+		show := strings.Replace(tags[0]+" "+tags[1][11:], "_", " ", -1)
+		if shows[show] == nil {
+			keys = append(keys, show)
+		}
+		shows[show] = append(shows[show], album)
+	}
+
+	sort.Strings(keys)
+	var numbered []string
+	for _, show := range keys {
+		if generateNumberShow(show, shows[show], published) {
+			numbered = append(numbered, show)
+		}
+	}
+
+	if len(numbered) > 0 {
+		generatePrintSources(numbered, shows)
+	}
+}
+
+// generateNumberShow numbers the albums of one show, returning
+// whether any of them isn't simply the only source
+func generateNumberShow(show string, albums []*generateAlbum, published *publishedRevisions) bool {
+	taken := make(map[int]string) // source to the album that has it
+
+	// folder names, so that the numbers don't depend on where generate is run from
+	sort.SliceStable(albums, func(i, j int) bool {
+		return albums[i].name < albums[j].name
+	})
+
+	var left []*generateAlbum
+	for _, album := range albums {
+		if source, ok := config.Sources[album.name]; ok {
+			if other, ok := taken[source]; ok {
+				album.sourceReason = fmt.Sprintf("sources config field gives Source %d to %s too", source, other)
+				left = append(left, album)
+				continue
+			}
+			album.source = source
+			album.sourceReason = "from the sources config field"
+			taken[source] = album.rel
+			continue
+		}
+		left = append(left, album)
+	}
+
+	var rest []*generateAlbum
+	for _, album := range left {
+		if source, ok := generateExistingSource(album, show); ok {
+			if _, ok := taken[source]; !ok {
+				album.source = source
+				album.sourceReason = "from the existing information file"
+				taken[source] = album.rel
+				continue
+			}
+		}
+		rest = append(rest, album)
+	}
+
+	// pages on the wiki are the page of the album they were published from,
+	// or are taken if they are from an album that isn't in the batch
+	byName := make(map[string]*generateAlbum)
+	numbered := make(map[*generateAlbum]bool)
+	for _, album := range albums {
+		byName[album.name] = album
+		numbered[album] = true
+	}
+	for _, album := range rest {
+		numbered[album] = false
+	}
+
+	onWiki := 0
+	for _, page := range generatePublishedSources(show, published) {
+		if _, ok := taken[page.source]; ok {
+			continue
+		}
+
+		if album, ok := byName[page.album]; ok {
+			if !numbered[album] {
+				album.source = page.source
+				album.sourceReason = "from the page published from it"
+				taken[page.source] = album.rel
+				numbered[album] = true
+			}
+			continue
+		}
+
+		taken[page.source] = "a page already on the wiki"
+		onWiki++
+	}
+
+	next := 1
+	for _, album := range rest {
+		if numbered[album] {
+			continue
+		}
+		for taken[next] != "" {
+			next++
+		}
+		album.source = next
+		if album.sourceReason == "" {
+			album.sourceReason = "next free number"
+		}
+		taken[next] = album.rel
+	}
+
+	return len(albums) > 1 || onWiki > 0 || albums[0].source != 1
+}
+
+type publishedSource struct {
+	source int
+	album  string // the folder name of the album it was published from, "" if it isn't known
+}
+
+// generatePublishedSources lists the sources of a show that were published to the wiki, in order
+func generatePublishedSources(show string, published *publishedRevisions) []publishedSource {
+	if published == nil {
+		return nil
+	}
+
+	var sources []publishedSource
+	for title := range published.Wikis[config.APIURL] {
+		matches := sourceTitleRegex.FindStringSubmatch(title)
+		if matches == nil || matches[1] != show {
+			continue
+		}
+		source, err := strconv.Atoi(matches[2])
+		if err != nil || source < 1 {
+			continue
+		}
+		sources = append(sources, publishedSource{source, published.album(title)})
+	}
+
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].source < sources[j].source
+	})
+	return sources
+}
+
+// generateExistingSource returns the source the information file of
+// an album already has, if it is for the same show
+func generateExistingSource(album *generateAlbum, show string) (int, bool) {
+	info, err := readInfoFile(fpath.Join(album.path, album.name+".txt"))
+	if err != nil {
+		return 0, false
+	}
+
+	title, err := wikiPageTitle(info)
+	if err != nil {
+		return 0, false
+	}
+
+	matches := sourceTitleRegex.FindStringSubmatch(title)
+	if matches == nil || matches[1] != show {
+		return 0, false
+	}
+	source, err := strconv.Atoi(matches[2])
+	return source, err == nil && source > 0
+}
+
+// generatePrintSources shows the source of every album of the shows given
+func generatePrintSources(keys []string, shows map[string][]*generateAlbum) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Show\tSource\tAlbum\t")
+	for _, show := range keys {
+		for _, album := range shows[show] {
+			fmt.Fprintf(w, "%s\t%d\t%s\t(%s)\n", show, album.source, album.rel, album.sourceReason)
+		}
+	}
+	w.Flush()
+	fmt.Println()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGenerateNumberShow(t *testing.T) {
+	config.APIURL = "https://dmlive.wiki/api.php"
+	defer func() { config.APIURL = "" }()
+
+	const show = "1990-05-02 Somewhere"
+	tests := []struct {
+		name      string
+		albums    []string
+		published map[string]string // page title to the album it was published from
+		want      map[string]int
+	}{
+		{
+			name:   "only source",
+			albums: []string{"album2"},
+			want:   map[string]int{"album2": 1},
+		},
+		{
+			name:   "by folder name",
+			albums: []string{"taper2", "album2"},
+			want:   map[string]int{"album2": 1, "taper2": 2},
+		},
+		{
+			// its information file was deleted, but it still has its page
+			name:      "published from the album",
+			albums:    []string{"album2"},
+			published: map[string]string{show + "/Source 1": "album2"},
+			want:      map[string]int{"album2": 1},
+		},
+		{
+			name:      "published from another album",
+			albums:    []string{"taper2"},
+			published: map[string]string{show + "/Source 1": "album2"},
+			want:      map[string]int{"taper2": 2},
+		},
+		{
+			name:      "published before albums were remembered",
+			albums:    []string{"taper2"},
+			published: map[string]string{show + "/Source 1": ""},
+			want:      map[string]int{"taper2": 2},
+		},
+		{
+			name:   "published from an album in the batch",
+			albums: []string{"album2", "taper2"},
+			published: map[string]string{
+				show + "/Source 1":              "taper2",
+				show + "/Source 3":              "other",
+				"1990-05-03 Elsewhere/Source 1": "album3",
+			},
+			want: map[string]int{"taper2": 1, "album2": 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var albums []*generateAlbum
+			for _, name := range test.albums {
+				albums = append(albums, &generateAlbum{album: album{path: t.TempDir(), name: name, rel: name}})
+			}
+
+			published := &publishedRevisions{
+				Wikis:  make(map[string]map[string]int),
+				Albums: make(map[string]map[string]string),
+			}
+			for title, album := range test.published {
+				published.set(title, 1, album)
+			}
+
+			generateNumberShow(show, albums, published)
+			for _, album := range albums {
+				if album.source != test.want[album.name] {
+					t.Errorf("%s is Source %d (%s), expected Source %d", album.name, album.source, album.sourceReason, test.want[album.name])
+				}
+			}
+		})
+	}
+}
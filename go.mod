@@ -4,6 +4,9 @@ go 1.18
 
 require (
 	github.com/inhies/go-bytesize v0.0.0-20220417184213-4913239db9cf
+	golang.org/x/crypto v0.9.0
 	gopkg.in/urfave/cli.v1 v1.20.0
 	gopkg.in/yaml.v2 v2.4.0
 )
+
+require golang.org/x/sys v0.8.0 // indirect
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// InfoFile is an information file (albumFolderName.txt), split up into its sections
+type InfoFile struct {
+	Header    []string // artist, date, album and tour
+	Lineage   string
+	Notes     string
+	Source    string // "This source is considered Source 1 for this date:"
+	SourceURL string
+	Tracks    []InfoTrack
+	TotalTime string
+	Footer    string
+
+	lines []string       // every line, so that the file can be written back out
+	spans map[string]int // section name to the index of its first line
+}
+
+// InfoTrack is a line from the track list, like "1.02. [4:03] Halo (*)"
+type InfoTrack struct {
+	Line        int
+	Prefix      string // "1." for tracks in CD1, "Bonus." for tracks in Bonus, empty otherwise
+	Index       int
+	Duration    string
+	Title       string
+	Annotations []Annotation
+}
+
+// InfoFileError points at the line of an information file that couldn't be understood
+type InfoFileError struct {
+	Line    int // 0 if the problem isn't on a particular line
+	Message string
+}
+
+func (e *InfoFileError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Each section starts with a line that has one of these prefixes
+const (
+	infoSectionLineage   = "Lineage:"
+	infoSectionNotes     = "Notes:"
+	infoSectionSource    = "This source is considered"
+	infoSectionTrackList = "Track list:"
+	infoSectionTotalTime = "Total time:"
+)
+
+var infoSections = []string{
+	infoSectionLineage,
+	infoSectionNotes,
+	infoSectionSource,
+	infoSectionTrackList,
+	infoSectionTotalTime,
+}
+
+// "01. [3:45] Title", with an optional "1." CD or "Bonus." folder prefix
+var infoTrackRegex = regexp.MustCompile(`^((?:[^.\[\]]+\.)?)(\d+)\. \[([^\]]*)\] (.*)$`)
+
+func readInfoFile(filename string) (*InfoFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseInfoFile(file)
+}
+
+// parseInfoFile reads an information file. Sections can be in any order,
+// but everything after the "Total time:" line is the footer.
+func parseInfoFile(r io.Reader) (*InfoFile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &InfoFile{spans: make(map[string]int)}
+	for _, line := range strings.Split(string(data), "\n") {
+		info.lines = append(info.lines, strings.TrimRight(line, "\r"))
+	}
+
+	// the last line each section starts on, before the footer, so that Lineage
+	// and Notes can have lines like "Track list:" typed in them
+	footer := len(info.lines)
+	for i := len(info.lines) - 1; i >= 0; i-- {
+		if infoSectionOf(info.lines[i]) == infoSectionTotalTime {
+			footer = i
+			break
+		}
+	}
+	last := make(map[string]int)
+	for i := 0; i < footer; i++ {
+		if next := infoSectionOf(info.lines[i]); next != "" {
+			last[next] = i
+		}
+	}
+
+	// the line each section was found on
+	found := make(map[string]int)
+	section := ""
+	var lines []string // of the current section
+
+	finishSection := func() error {
+		text := strings.TrimSpace(strings.Join(lines, "\n"))
+		lines = nil
+
+		switch section {
+		case infoSectionLineage:
+			info.Lineage = text
+		case infoSectionNotes:
+			info.Notes = text
+		case infoSectionSource:
+			if text == "" {
+				return &InfoFileError{found[section] + 1, "expected the source URL on the line after \"" + info.Source + "\""}
+			}
+			info.SourceURL = strings.TrimSpace(strings.SplitN(text, "\n", 2)[0])
+		}
+		return nil
+	}
+
+	for i, line := range info.lines {
+		lineNumber := i + 1
+
+		// The footer is everything after the total time
+		if section == infoSectionTotalTime {
+			lines = append(lines, line)
+			continue
+		}
+
+		next := infoSectionOf(line)
+		if next != "" && (section == infoSectionLineage || section == infoSectionNotes) {
+			// typed by hand, unless it is where the section really starts
+			if _, ok := found[next]; ok || last[next] > i || (next == infoSectionTotalTime && i != footer) {
+				next = ""
+			}
+		}
+
+		if next != "" {
+			if first, ok := found[next]; ok {
+				return nil, &InfoFileError{lineNumber, fmt.Sprintf("%q appears twice (first on line %d)", next, first)}
+			}
+			if err := finishSection(); err != nil {
+				return nil, err
+			}
+
+			found[next] = lineNumber
+			info.spans[next] = i
+			section = next
+
+			rest := strings.TrimSpace(strings.TrimPrefix(line, next))
+			switch next {
+			case infoSectionSource:
+				info.Source = strings.TrimSpace(line)
+			case infoSectionTrackList:
+				if rest != "" {
+					return nil, &InfoFileError{lineNumber, "tracks should start on the line after \"Track list:\""}
+				}
+			case infoSectionTotalTime:
+				if _, ok := found[infoSectionTrackList]; !ok {
+					return nil, &InfoFileError{lineNumber, "\"Total time:\" should come after the track list"}
+				}
+				info.TotalTime = rest
+			default:
+				lines = append(lines, rest)
+			}
+			continue
+		}
+
+		switch section {
+		case "":
+			if trimmed := strings.TrimSpace(line); trimmed != "" {
+				info.Header = append(info.Header, trimmed)
+			}
+		case infoSectionTrackList:
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			track, err := parseInfoTrack(line, lineNumber)
+			if err != nil {
+				return nil, err
+			}
+			info.Tracks = append(info.Tracks, track)
+		default:
+			lines = append(lines, line)
+		}
+	}
+	if section == infoSectionTotalTime {
+		info.Footer = strings.TrimSpace(strings.Join(lines, "\n"))
+	} else if err := finishSection(); err != nil {
+		return nil, err
+	}
+
+	for _, name := range infoSections {
+		if _, ok := found[name]; !ok {
+			return nil, &InfoFileError{0, fmt.Sprintf("missing the %q section", name)}
+		}
+	}
+
+	if len(info.Tracks) == 0 {
+		return nil, &InfoFileError{found[infoSectionTrackList], "the track list is empty"}
+	}
+
+	return info, nil
+}
+
+func infoSectionOf(line string) string {
+	for _, name := range infoSections {
+		if strings.HasPrefix(line, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+func parseInfoTrack(line string, lineNumber int) (track InfoTrack, err error) {
+	matches := infoTrackRegex.FindStringSubmatch(strings.TrimSpace(line))
+	if matches == nil {
+		return track, &InfoFileError{lineNumber, fmt.Sprintf("expected a track like \"01. [3:45] Title\", got %q", strings.TrimSpace(line))}
+	}
+
+	track.Line = lineNumber
+	track.Prefix = matches[1]
+	track.Index, err = strconv.Atoi(matches[2])
+	if err != nil {
+		return track, &InfoFileError{lineNumber, fmt.Sprintf("track number %q is too big", matches[2])}
+	}
+	track.Duration = matches[3]
+
+	track.Title, track.Annotations = splitAnnotations(strings.TrimSpace(matches[4]))
+
+	return track, nil
+}
+
+// PageName returns the part of the source URL after the wiki path,
+// like "1990-05-02_Somewhere/Source_1"
+func (info *InfoFile) PageName() string {
+	if name := strings.TrimPrefix(info.SourceURL, config.WikiPath+"/"); name != info.SourceURL {
+		return name
+	}
+
+	// the wiki path might have changed since the file was generated
+	if i := strings.LastIndex(info.SourceURL, "wiki/"); i != -1 {
+		return info.SourceURL[i+len("wiki/"):]
+	}
+	return info.SourceURL
+}
+
+// sectionLines returns the lines of a section, leaving out blank lines at the end
+func (info *InfoFile) sectionLines(name string) (start, end int) {
+	start = info.spans[name]
+	end = len(info.lines)
+	for _, other := range info.spans {
+		if other > start && other < end {
+			end = other
+		}
+	}
+
+	for end > start+1 && strings.TrimSpace(info.lines[end-1]) == "" {
+		end--
+	}
+	return
+}
+
+// merge copies the sections that are edited by hand
+// (Lineage and Notes) from old, keeping them verbatim
+func (info *InfoFile) merge(old *InfoFile) {
+	info.Lineage = old.Lineage
+	info.Notes = old.Notes
+
+	// Replace the last section first, so the start of the other doesn't move
+	names := []string{infoSectionLineage, infoSectionNotes}
+	if info.spans[infoSectionLineage] > info.spans[infoSectionNotes] {
+		names[0], names[1] = names[1], names[0]
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+		start, end := info.sectionLines(name)
+		oldStart, oldEnd := old.sectionLines(name)
+
+		var lines []string
+		lines = append(lines, info.lines[:start]...)
+		lines = append(lines, old.lines[oldStart:oldEnd]...)
+		lines = append(lines, info.lines[end:]...)
+
+		// everything after this section has moved
+		moved := (oldEnd - oldStart) - (end - start)
+		for other, index := range info.spans {
+			if index > start {
+				info.spans[other] = index + moved
+			}
+		}
+		info.lines = lines
+	}
+}
+
+// Bytes writes the information file back out, with windows line endings
+func (info *InfoFile) Bytes() []byte {
+	return []byte(strings.Join(info.lines, "\r\n"))
+}
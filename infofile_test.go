@@ -0,0 +1,231 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// infoFileText joins lines with windows line endings, like generate writes them
+func infoFileText(lines ...string) string {
+	return strings.Join(lines, "\r\n")
+}
+
+var infoFileHeader = []string{
+	"Depeche Mode",
+	"1990-05-02",
+	"Somewhere",
+	"World Violation Tour",
+	"",
+}
+
+var infoFileRest = []string{
+	"This source is considered Source 1 for this date:",
+	"https://dmlive.wiki/wiki/1990-05-02_Somewhere/Source_1",
+	"",
+	"Track list:",
+	"",
+	"1.01. [4:03] Halo",
+	"1.02. [3:45] Enjoy The Silence",
+	"Bonus.01. [1:00] Soundcheck",
+	"",
+	"Total time: 8:48",
+	"",
+	"Recording freely provided by the Depeche Mode Live Wiki",
+}
+
+func infoFileWith(middle ...string) string {
+	var lines []string
+	lines = append(lines, infoFileHeader...)
+	lines = append(lines, middle...)
+	lines = append(lines, infoFileRest...)
+	return infoFileText(lines...)
+}
+
+func TestParseInfoFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		lineage string
+		notes   string
+		err     string
+	}{
+		{
+			name:    "generated",
+			text:    infoFileWith("Lineage: ", "", "Notes: ", ""),
+			lineage: "",
+			notes:   "",
+		},
+		{
+			name:    "filled in",
+			text:    infoFileWith("Lineage: AKG > DAT", "> FLAC", "", "Notes: ", "Great show.", "", "  Indented too.", ""),
+			lineage: "AKG > DAT\n> FLAC",
+			notes:   "Great show.\n\n  Indented too.",
+		},
+		{
+			name:    "sections in another order",
+			text:    infoFileWith("Notes: first", "", "Lineage: second", ""),
+			lineage: "second",
+			notes:   "first",
+		},
+		{
+			// lines typed in the notes that look like the start of a section
+			name:    "section names in the notes",
+			text:    infoFileWith("Lineage: AKG", "", "Notes: ", "Lineage: unknown before the DAT", "Track list: shortened", "Total time: about 80 minutes", "This source is considered the best", ""),
+			lineage: "AKG",
+			notes:   "Lineage: unknown before the DAT\nTrack list: shortened\nTotal time: about 80 minutes\nThis source is considered the best",
+		},
+		{
+			name:    "section names in the lineage",
+			text:    infoFileWith("Lineage: ", "Notes: taken from the master", "", "Notes: real notes", ""),
+			lineage: "Notes: taken from the master",
+			notes:   "real notes",
+		},
+		{
+			name: "missing notes",
+			text: infoFileWith("Lineage: ", ""),
+			err:  `missing the "Notes:" section`,
+		},
+		{
+			name: "twice",
+			text: infoFileText(append(append(infoFileHeader, "Lineage:", "Notes:", "This source is considered Source 1 for this date:", "url", "Track list:", "01. [1:00] Intro", "Track list:"), infoFileRest[5:]...)...),
+			err:  `line 12: "Track list:" appears twice (first on line 10)`,
+		},
+		{
+			name: "bad track",
+			text: infoFileText(append(append(infoFileHeader, "Lineage:", "Notes:", "This source is considered Source 1 for this date:", "url", "Track list:", "Halo"), infoFileRest[9:]...)...),
+			err:  `line 11: expected a track like "01. [3:45] Title", got "Halo"`,
+		},
+		{
+			name: "no source url",
+			text: infoFileText(append(append(infoFileHeader, "Lineage:", "Notes:", "This source is considered Source 1 for this date:", ""), infoFileRest[3:]...)...),
+			err:  `line 9: expected the source URL on the line after "This source is considered Source 1 for this date:"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := parseInfoFile(strings.NewReader(test.text))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("parseInfoFile error = %v, expected %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseInfoFile: %v", err)
+			}
+
+			if info.Lineage != test.lineage {
+				t.Errorf("Lineage = %q, expected %q", info.Lineage, test.lineage)
+			}
+			if info.Notes != test.notes {
+				t.Errorf("Notes = %q, expected %q", info.Notes, test.notes)
+			}
+
+			if !reflect.DeepEqual(info.Header, infoFileHeader[:4]) {
+				t.Errorf("Header = %q", info.Header)
+			}
+			if info.SourceURL != "https://dmlive.wiki/wiki/1990-05-02_Somewhere/Source_1" {
+				t.Errorf("SourceURL = %q", info.SourceURL)
+			}
+			if info.TotalTime != "8:48" || info.Footer != "Recording freely provided by the Depeche Mode Live Wiki" {
+				t.Errorf("TotalTime = %q, Footer = %q", info.TotalTime, info.Footer)
+			}
+
+			var tracks []string
+			for _, track := range info.Tracks {
+				tracks = append(tracks, track.Prefix+"|"+track.Duration+"|"+track.Title)
+			}
+			want := []string{"1.|4:03|Halo", "1.|3:45|Enjoy The Silence", "Bonus.|1:00|Soundcheck"}
+			if !reflect.DeepEqual(tracks, want) {
+				t.Errorf("Tracks = %q, expected %q", tracks, want)
+			}
+
+			if string(info.Bytes()) != test.text {
+				t.Errorf("Bytes didn't give back the file it was parsed from:\n%q", info.Bytes())
+			}
+		})
+	}
+}
+
+func TestInfoFileMerge(t *testing.T) {
+	// typed by hand, with trailing spaces, blank lines and a section name
+	lineage := []string{"Lineage: AKG C568EB > Sony TCD-D3  ", "", "  > Audacity > FLAC"}
+	notes := []string{"Notes: ", "Track list: the encore was cut", "", "\tTabbed."}
+
+	old := infoFileWith(append(append(append(lineage, ""), notes...), "", "")...)
+	generated := infoFileText(
+		"Depeche Mode",
+		"1990-05-02",
+		"Somewhere",
+		"World Violation Tour",
+		"",
+		"Lineage: ",
+		"",
+		"Notes: ",
+		"",
+		"This source is considered Source 2 for this date:",
+		"https://dmlive.wiki/wiki/1990-05-02_Somewhere/Source_2",
+		"",
+		"Track list:",
+		"",
+		"01. [4:04] Halo",
+		"",
+		"Total time: 4:04",
+		"",
+		"New footer",
+	)
+
+	oldInfo, err := parseInfoFile(strings.NewReader(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := parseInfoFile(strings.NewReader(generated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.merge(oldInfo)
+
+	want := infoFileText(
+		"Depeche Mode",
+		"1990-05-02",
+		"Somewhere",
+		"World Violation Tour",
+		"",
+		"Lineage: AKG C568EB > Sony TCD-D3  ",
+		"",
+		"  > Audacity > FLAC",
+		"",
+		"Notes: ",
+		"Track list: the encore was cut",
+		"",
+		"\tTabbed.",
+		"",
+		"This source is considered Source 2 for this date:",
+		"https://dmlive.wiki/wiki/1990-05-02_Somewhere/Source_2",
+		"",
+		"Track list:",
+		"",
+		"01. [4:04] Halo",
+		"",
+		"Total time: 4:04",
+		"",
+		"New footer",
+	)
+	if got := string(info.Bytes()); got != want {
+		t.Errorf("merged file:\n%s\nexpected:\n%s", got, want)
+	}
+
+	// and it reads back with the same sections
+	merged, err := parseInfoFile(strings.NewReader(string(info.Bytes())))
+	if err != nil {
+		t.Fatalf("parsing the merged file: %v", err)
+	}
+	if merged.Lineage != oldInfo.Lineage || merged.Notes != oldInfo.Notes {
+		t.Errorf("merged Lineage = %q and Notes = %q, expected %q and %q", merged.Lineage, merged.Notes, oldInfo.Lineage, oldInfo.Notes)
+	}
+	if len(merged.Tracks) != 1 || merged.SourceURL != "https://dmlive.wiki/wiki/1990-05-02_Somewhere/Source_2" || merged.Footer != "New footer" {
+		t.Errorf("merged file lost the generated sections: %+v", merged)
+	}
+}
@@ -1,53 +1,23 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	"runtime"
+	"time"
 
 	"gopkg.in/urfave/cli.v1"
 )
 
-var metaflacPath string
-
-func findMetaflac() (string, error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return "", err
-	}
-
-	// _, err = os.Stat("./metaflac")
-	// if err == nil {
-	// 	// absolute path to metaflac relative to cwd
-	// 	return filepath.Join(cwd, "metaflac"), nil
-	// }
-
-	path, err := exec.LookPath("metaflac")
-	if errors.Is(err, exec.ErrDot) {
-		return cwd + "/" + path, nil
-	} else if err != nil {
-		return "", err
-	}
-	return path, nil
-}
-
 func main() {
-	var err error
-	metaflacPath, err = findMetaflac()
-	if err != nil {
-		fmt.Println(err.Error())
-		return
-	}
-
 	configPath := os.Getenv("config")
 	if configPath == "" {
 		configPath = "config.yaml"
 	}
 	if len(os.Args) > 1 {
 		if err := parseConfig(configPath); err != nil {
-			fmt.Println(err.Error())
-			return
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(exitUsage)
 		}
 	}
 
@@ -57,6 +27,7 @@ func main() {
 	app.Author = `Qais "qaisjp" Patankar`
 	app.Email = "me@qaisjp.com"
 	app.Version = "1.1"
+	app.OnUsageError = onUsageError
 
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
@@ -71,98 +42,189 @@ func main() {
 			Name:  "single, s",
 			Usage: "parse the directory given, not the subdirectories",
 		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "don't change anything, just show which files would be created, overwritten or deleted",
+		},
 	}
 
 	app.Commands = []cli.Command{
 		{
-			Name:   "checksum",
-			Usage:  "perform a checksum of directories",
-			Action: performChecksum,
+			Name:         "checksum",
+			Usage:        "perform a checksum of directories",
+			Action:       performChecksum,
+			OnUsageError: onUsageError,
+			Flags: append([]cli.Flag{
+				cli.IntFlag{
+					Name:  "jobs, j",
+					Value: runtime.NumCPU(),
+					Usage: "number of files to hash at the same time",
+				},
+				cli.StringFlag{
+					Name:  "algo",
+					Value: "md5",
+					Usage: "comma separated checksum algorithms to write (md5, sha1, sha256, blake2b)",
+				},
+				cli.BoolFlag{
+					Name:  "full",
+					Usage: "hash every file, even ones that haven't changed since they were last hashed",
+				},
+			}, albumFilterFlags...),
 		},
 		{
-			Name:   "verify",
-			Usage:  "verify ffp and md5 files in directories",
-			Action: verifyChecksum,
+			Name:         "verify",
+			Usage:        "verify ffp and md5 files in directories",
+			Action:       verifyChecksum,
+			OnUsageError: onUsageError,
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{
+					Name:  "deep",
+					Usage: "decode the audio of every flac file and check it against its signature",
+				},
+				cli.BoolFlag{
+					Name:  "quick",
+					Usage: "trust the cached hashes of files that haven't changed since they were last hashed",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "text",
+					Usage: "report format: text, json or junit",
+				},
+			}, albumFilterFlags...),
 		},
 		{
-			Name:   "generate",
-			Usage:  "generate dirname.txt Infofile's for the passed directory",
-			Action: generateInformation,
-			Flags: []cli.Flag{
+			Name:         "generate",
+			Usage:        "generate dirname.txt Infofile's for the passed directory",
+			Action:       generateInformation,
+			OnUsageError: onUsageError,
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "tour",
-					Usage: "required: the tour name for this directory",
+					Usage: "the tour name for this directory, required unless it can be worked out from the dates in --tour-file",
 				},
 				cli.StringFlag{
 					Name:  "tour-file",
-					Usage: "file with list of tracks with alternate vocals",
+					Usage: "tours.yaml file (or an old tourfile.txt) describing the tour",
+				},
+				cli.BoolFlag{
+					Name:  "ignore-bad-tracks",
+					Usage: "leave unreadable tracks out of the album instead of skipping the album",
+				},
+				cli.BoolFlag{
+					Name:  "merge",
+					Usage: "refresh an existing .txt file from the tags, keeping its Lineage and Notes",
+				},
+			}, albumFilterFlags...),
+		},
+		{
+			Name:         "wiki",
+			Usage:        "generate dirname.wiki Wikifile's for the passed directory",
+			Action:       generateWikifiles,
+			OnUsageError: onUsageError,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Value: "files",
+					Usage: "files (a .wiki file for each album) or xml-dump (every page in one dump.xml, for Special:Import or importDump.php)",
+				},
+				cli.StringFlag{
+					Name:  "summary",
+					Value: "Imported by dmlivewiki",
+					Usage: "the edit summary of each page in an xml-dump",
+				},
+				cli.StringFlag{
+					Name:  "contributor",
+					Usage: "the user each page in an xml-dump is from, defaults to the account of botUsername or \"dmlivewiki\"",
+				},
+			}, albumFilterFlags...),
+			Subcommands: []cli.Command{
+				{
+					Name:         "decode-title",
+					Usage:        "print the page title of .wiki files, from the manifest.json next to them or their filename",
+					ArgsUsage:    "<file.wiki>...",
+					Action:       decodeWikiTitles,
+					OnUsageError: onUsageError,
+				},
+				{
+					Name:         "diff",
+					Usage:        "show how each page on the wiki differs from what publish would save, and which were edited on the wiki",
+					ArgsUsage:    "<directory>",
+					Action:       diffWikifiles,
+					OnUsageError: onUsageError,
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name:  "dump",
+							Usage: "compare with an XML dump from Special:Export instead of the live wiki",
+						},
+					}, albumFilterFlags...),
 				},
 			},
 		},
 		{
-			Name:   "wiki",
-			Usage:  "generate dirname.wiki Wikifile's for the passed directory",
-			Action: generateWikifiles,
+			Name:         "publish",
+			Usage:        "publish the wiki page of each album in the passed directory straight to the wiki",
+			Action:       publishWikifiles,
+			OnUsageError: onUsageError,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "summary",
+					Value: "Updated by dmlivewiki",
+					Usage: "the edit summary",
+				},
+				cli.BoolFlag{
+					Name:  "bot",
+					Usage: "mark the edits as bot edits, hiding them from recent changes",
+				},
+				cli.DurationFlag{
+					Name:  "delay",
+					Value: time.Second,
+					Usage: "the shortest time between two edits",
+				},
+				cli.IntFlag{
+					Name:  "maxlag",
+					Value: 5,
+					Usage: "wait and try again when the wiki's database is lagged by more than this many seconds",
+				},
+			}, albumFilterFlags...),
+		},
+		{
+			Name:  "tours",
+			Usage: "work with tour files",
+			Subcommands: []cli.Command{
+				{
+					Name:         "convert",
+					Usage:        "convert an old tourfile.txt to tours.yaml (defaults to next to the tourfile)",
+					ArgsUsage:    "<tourfile.txt> [tours.yaml]",
+					Action:       convertTourFile,
+					OnUsageError: onUsageError,
+				},
+			},
 		},
 		{
-			Name:   "find",
-			Usage:  "finds unfilled .txt files for the passed directory",
-			Action: findWikifiles,
+			Name:  "templates",
+			Usage: "work with the information and wiki templates",
+			Subcommands: []cli.Command{
+				{
+					Name:         "dump",
+					Usage:        "write the built in templates to a directory (defaults to the current one) so they can be edited",
+					ArgsUsage:    "[directory]",
+					Action:       dumpTemplates,
+					OnUsageError: onUsageError,
+				},
+			},
+		},
+		{
+			Name:         "find",
+			Usage:        "finds unfilled .txt files for the passed directory",
+			Action:       findWikifiles,
+			OnUsageError: onUsageError,
+			Flags:        albumFilterFlags,
 		},
 	}
 
+	// Errors with an exit code have already been printed by cli
 	if err := app.Run(os.Args); err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, "Error:", err.Error())
+		os.Exit(exitFailure)
 	}
 }
-
-// Information template to write .txt info files from a folder
-var informationTemplate = `{{.Artist}}
-{{.Date}}
-{{.Album}}
-{{.Tour}}
-
-Lineage: 
-
-Notes: 
-
-This source is considered Source 1 for this date:
-$$wikiPath$$/{{wikiescape .Date}}_{{wikiescape .Album}}/Source_1
-
-Track list:
-
-{{range .Tracks}}{{.Prefix}}{{printf "%02d" .Index}}. [{{.Duration}}] {{.Title}}{{if .HasAlternateLeadVocalist}} (*){{end}}
-{{end}}Total time: {{.Duration}}
-
-$$footer$$`
-
-// Wiki template to write the .wiki files from edited .txt info files
-var wikiTemplate = `== Notes ==
-
-{{.Notes}}
-
-== Listen ==
-
-You can listen to this entire recording below.
-
-<html5media>$$streamPath$$/{{.FolderName}}/complete.m4a</html5media>
-
-== Track list ==
-
-{{range .Tracks}}{{.LinePrefix}}[{{.Duration}}] <sm2>$$streamPath$$/{{.FolderName}}/{{printf "%02d" .Index}}.m4a</sm2> [[{{.Name}}]]{{if .HasAlternateLeadVocalist}} {{"{{"}}tt|(*)|Vocals by Martin Gore{{"}}"}}{{end}}
-{{end}}*Total time: {{.Duration}}
-
-== Lineage ==
-
-{{.Lineage}}
-== Download ==
-
-*[$$downloadPath$$/{{.FolderName}}.zip Download ZIP] - FLAC {{.BPS}}-bit {{.SampleRate}} - {{.Size}}
-
-[[Category:Audience recordings]]
-[[Category:Source]]
-[[Category:Streamable]]
-`
-
-// Wiki regex to read an edited .txt info file and extract what is needed for a .wiki file
-const wikiRegexText = `((?:.*[\r\n])?)Lineage: ((?:.|[\r\n]+)*)[\r\n]+Notes: ((?:.|[\r\n]+)*)[\r\n]+This source is conside(?:.|[\r\n])*wiki\/(.*)[\r\n]*Track list:[\r\n]+[\r\n]+((?:.|[\r\n]+)*)[\r\n]+Total time: (.*)`
@@ -0,0 +1,156 @@
+package mediawiki
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+)
+
+// The version of the Special:Export format written by WriteDump
+const (
+	dumpVersion   = "0.11"
+	dumpNamespace = "http://www.mediawiki.org/xml/export-0.11/"
+)
+
+type dump struct {
+	XMLName        xml.Name   `xml:"mediawiki"`
+	XMLNS          string     `xml:"xmlns,attr"`
+	XSI            string     `xml:"xmlns:xsi,attr"`
+	SchemaLocation string     `xml:"xsi:schemaLocation,attr"`
+	Version        string     `xml:"version,attr"`
+	Lang           string     `xml:"xml:lang,attr"`
+	Pages          []DumpPage `xml:"page"`
+}
+
+// DumpPage is a <page> in an XML dump made by Special:Export or dumpBackup.php
+type DumpPage struct {
+	Title     string         `xml:"title"`
+	NS        int            `xml:"ns"`
+	Revisions []DumpRevision `xml:"revision"`
+}
+
+type DumpRevision struct {
+	ID          int             `xml:"id,omitempty"`
+	Timestamp   time.Time       `xml:"timestamp"`
+	Contributor DumpContributor `xml:"contributor"`
+	Comment     string          `xml:"comment,omitempty"`
+	Model       string          `xml:"model,omitempty"`
+	Format      string          `xml:"format,omitempty"`
+	Text        DumpText        `xml:"text"`
+}
+
+type DumpContributor struct {
+	Username string `xml:"username,omitempty"`
+	IP       string `xml:"ip,omitempty"`
+}
+
+type DumpText struct {
+	Space string `xml:"xml:space,attr,omitempty"`
+	Bytes string `xml:"bytes,attr,omitempty"`
+	Text  string `xml:",chardata"`
+}
+
+// MarshalXML writes the text as it is, as the encoder would otherwise
+// escape every newline, leaving the whole page on one line
+func (t DumpText) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if t.Space != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xml:space"}, Value: t.Space})
+	}
+	if t.Bytes != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "bytes"}, Value: t.Bytes})
+	}
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := e.EncodeToken(xml.CharData(t.Text)); err != nil {
+		return err
+	}
+	return e.EncodeToken(start.End())
+}
+
+// NewDumpRevision makes a wikitext revision, ready to be imported
+func NewDumpRevision(text string, timestamp time.Time, username string, comment string) DumpRevision {
+	return DumpRevision{
+		Timestamp:   timestamp.UTC().Truncate(time.Second),
+		Contributor: DumpContributor{Username: username},
+		Comment:     comment,
+		Model:       "wikitext",
+		Format:      "text/x-wiki",
+		Text: DumpText{
+			Space: "preserve",
+			Bytes: strconv.Itoa(len(text)),
+			Text:  text,
+		},
+	}
+}
+
+// WriteDump writes pages in the format of Special:Export, which can be
+// imported with Special:Import or the importDump.php maintenance script
+func WriteDump(w io.Writer, pages []DumpPage) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err := encoder.Encode(dump{
+		XMLNS:          dumpNamespace,
+		XSI:            "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: dumpNamespace + " http://www.mediawiki.org/xml/export-" + dumpVersion + ".xsd",
+		Version:        dumpVersion,
+		Lang:           "en",
+		Pages:          pages,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// ReadDump reads the latest revision of every page in an XML dump, by title.
+// Pages are read one at a time, so that big dumps don't have to fit in memory.
+func ReadDump(r io.Reader) (map[string]Revision, error) {
+	revisions := make(map[string]Revision)
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return revisions, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "page" {
+			continue
+		}
+
+		var page DumpPage
+		if err := decoder.DecodeElement(&page, &start); err != nil {
+			return nil, err
+		}
+
+		revision := Revision{Title: page.Title, Missing: true}
+		for _, r := range page.Revisions {
+			if revision.Missing || r.Timestamp.After(revision.Timestamp) {
+				user := r.Contributor.Username
+				if user == "" {
+					user = r.Contributor.IP
+				}
+				revision = Revision{
+					Title:     page.Title,
+					ID:        r.ID,
+					Timestamp: r.Timestamp,
+					User:      user,
+					Text:      r.Text.Text,
+				}
+			}
+		}
+		revisions[page.Title] = revision
+	}
+}
@@ -0,0 +1,404 @@
+// Package mediawiki is a small client for the MediaWiki Action API
+// (https://www.mediawiki.org/wiki/API:Main_page), with just
+// enough of it to log in with a bot password and edit pages.
+package mediawiki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to the api.php of a wiki. Its fields can be
+// changed after NewClient, but not while it is being used.
+type Client struct {
+	APIURL    string // like "https://dmlive.wiki/api.php"
+	HTTP      *http.Client
+	UserAgent string
+
+	MaxLag  int           // seconds of database lag after which the wiki should turn us away, 0 to not ask
+	Retries int           // how many times to try again when turned away because of lag
+	Delay   time.Duration // the shortest time between two edits
+
+	// Sleep waits between retries and edits, it's time.Sleep unless replaced
+	Sleep func(time.Duration)
+
+	csrfToken string
+	lastEdit  time.Time
+}
+
+func NewClient(apiURL string) (*Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		APIURL:    apiURL,
+		HTTP:      &http.Client{Jar: jar, Timeout: time.Minute},
+		UserAgent: "dmlivewiki (https://github.com/qaisjp/dmlivewiki)",
+		MaxLag:    5,
+		Retries:   5,
+		Delay:     time.Second,
+		Sleep:     time.Sleep,
+	}, nil
+}
+
+// APIError is an error returned by the wiki, like "badtoken" or "maxlag"
+type APIError struct {
+	Code string `json:"code"`
+	Info string `json:"info"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Info, e.Code)
+}
+
+// IsAPIError reports whether err came from the wiki with this code
+func IsAPIError(err error, code string) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// Login logs in with a bot password, made at Special:BotPasswords.
+// The username looks like "Qais@dmlivewiki".
+func (c *Client) Login(username string, password string) error {
+	token, err := c.token("login")
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Login struct {
+			Result string `json:"result"`
+			Reason string `json:"reason"`
+		} `json:"login"`
+	}
+	err = c.post(url.Values{
+		"action":     {"login"},
+		"lgname":     {username},
+		"lgpassword": {password},
+		"lgtoken":    {token},
+	}, &response)
+	if err != nil {
+		return err
+	}
+
+	if response.Login.Result != "Success" {
+		reason := response.Login.Reason
+		if reason == "" {
+			reason = response.Login.Result
+		}
+		return fmt.Errorf("could not log in as %s (%s)", username, reason)
+	}
+
+	// tokens from before logging in belong to an anonymous user
+	c.csrfToken = ""
+	return nil
+}
+
+// token fetches a token of the given type, like "login" or "csrf"
+func (c *Client) token(kind string) (string, error) {
+	var response struct {
+		Query struct {
+			Tokens map[string]string `json:"tokens"`
+		} `json:"query"`
+	}
+	err := c.get(url.Values{
+		"action": {"query"},
+		"meta":   {"tokens"},
+		"type":   {kind},
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+
+	token := response.Query.Tokens[kind+"token"]
+	if token == "" {
+		return "", fmt.Errorf("the wiki didn't give us a %s token", kind)
+	}
+	return token, nil
+}
+
+// Edit is a change to make to a page
+type Edit struct {
+	Title   string
+	Text    string
+	Summary string
+	Bot     bool // mark the edit as a bot edit, so it can be hidden from recent changes
+}
+
+// EditResult is what the wiki did with an edit
+type EditResult struct {
+	Title    string
+	Result   string
+	New      bool // the page was created
+	NoChange bool // the page already had this text
+	OldRevID int
+	NewRevID int
+}
+
+// Edit creates or replaces the text of a page. Edits are spaced
+// out by Delay, so that the wiki isn't flooded.
+func (c *Client) Edit(edit Edit) (*EditResult, error) {
+	if wait := c.Delay - time.Since(c.lastEdit); wait > 0 {
+		c.Sleep(wait)
+	}
+	defer func() {
+		c.lastEdit = time.Now()
+	}()
+
+	// the token might have expired, so get a new one and try again
+	for attempt := 0; ; attempt++ {
+		result, err := c.edit(edit)
+		if IsAPIError(err, "badtoken") && attempt == 0 {
+			c.csrfToken = ""
+			continue
+		}
+		return result, err
+	}
+}
+
+func (c *Client) edit(edit Edit) (*EditResult, error) {
+	if c.csrfToken == "" {
+		token, err := c.token("csrf")
+		if err != nil {
+			return nil, err
+		}
+		c.csrfToken = token
+	}
+
+	// Encode sorts the keys, so the token is sent last
+	// and a request that is cut off is never accepted
+	params := url.Values{
+		"action":  {"edit"},
+		"title":   {edit.Title},
+		"text":    {edit.Text},
+		"summary": {edit.Summary},
+		"token":   {c.csrfToken},
+	}
+	if edit.Bot {
+		params.Set("bot", "1")
+	}
+
+	var response struct {
+		Edit *struct {
+			Title    string  `json:"title"`
+			Result   string  `json:"result"`
+			New      *string `json:"new"` // these are "" when set, and missing otherwise
+			NoChange *string `json:"nochange"`
+			OldRevID int     `json:"oldrevid"`
+			NewRevID int     `json:"newrevid"`
+		} `json:"edit"`
+	}
+	if err := c.post(params, &response); err != nil {
+		return nil, err
+	}
+	if response.Edit == nil {
+		return nil, errors.New("the wiki didn't say what happened to the edit")
+	}
+
+	edited := response.Edit
+	result := EditResult{
+		Title:    edited.Title,
+		Result:   edited.Result,
+		New:      edited.New != nil,
+		NoChange: edited.NoChange != nil,
+		OldRevID: edited.OldRevID,
+		NewRevID: edited.NewRevID,
+	}
+	if result.Result != "Success" {
+		return &result, fmt.Errorf("edit to %s failed (%s)", edit.Title, result.Result)
+	}
+	return &result, nil
+}
+
+// Revision is the latest revision of a page
+type Revision struct {
+	Title     string
+	Missing   bool // the page doesn't exist, and nothing else is set
+	ID        int
+	Timestamp time.Time
+	User      string
+	Text      string
+}
+
+// The most titles that can be asked for at once, for clients that aren't bots
+const maxTitles = 50
+
+// Pages fetches the latest revision of every page, in the same order as the titles
+func (c *Client) Pages(titles []string) ([]Revision, error) {
+	var revisions []Revision
+	for start := 0; start < len(titles); start += maxTitles {
+		end := start + maxTitles
+		if end > len(titles) {
+			end = len(titles)
+		}
+
+		batch, err := c.pages(titles[start:end])
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, batch...)
+	}
+	return revisions, nil
+}
+
+func (c *Client) pages(titles []string) ([]Revision, error) {
+	var response struct {
+		Query struct {
+			Normalized []struct {
+				From string `json:"from"`
+				To   string `json:"to"`
+			} `json:"normalized"`
+			Pages []struct {
+				Title     string `json:"title"`
+				Missing   bool   `json:"missing"`
+				Invalid   bool   `json:"invalid"`
+				Revisions []struct {
+					ID        int       `json:"revid"`
+					Timestamp time.Time `json:"timestamp"`
+					User      string    `json:"user"`
+					Slots     struct {
+						Main struct {
+							Content string `json:"content"`
+						} `json:"main"`
+					} `json:"slots"`
+				} `json:"revisions"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	err := c.get(url.Values{
+		"action":        {"query"},
+		"prop":          {"revisions"},
+		"rvprop":        {"ids|timestamp|user|content"},
+		"rvslots":       {"main"},
+		"titles":        {strings.Join(titles, "|")},
+		"formatversion": {"2"},
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	// the wiki tidies titles up, like "a_b" to "A b"
+	normalized := make(map[string]string)
+	for _, n := range response.Query.Normalized {
+		normalized[n.From] = n.To
+	}
+
+	found := make(map[string]Revision)
+	for _, page := range response.Query.Pages {
+		if page.Invalid {
+			continue
+		}
+
+		revision := Revision{Title: page.Title, Missing: page.Missing || len(page.Revisions) == 0}
+		if !revision.Missing {
+			latest := page.Revisions[0]
+			revision.ID = latest.ID
+			revision.Timestamp = latest.Timestamp
+			revision.User = latest.User
+			revision.Text = latest.Slots.Main.Content
+		}
+		found[page.Title] = revision
+	}
+
+	revisions := make([]Revision, len(titles))
+	for i, title := range titles {
+		name := title
+		if to, ok := normalized[title]; ok {
+			name = to
+		}
+
+		revision, ok := found[name]
+		if !ok {
+			return nil, fmt.Errorf("%q isn't a valid page title", title)
+		}
+		revisions[i] = revision
+	}
+	return revisions, nil
+}
+
+func (c *Client) get(params url.Values, v interface{}) error {
+	return c.call(http.MethodGet, params, v)
+}
+
+func (c *Client) post(params url.Values, v interface{}) error {
+	return c.call(http.MethodPost, params, v)
+}
+
+// call makes a request, trying again while the wiki is lagged
+func (c *Client) call(method string, params url.Values, v interface{}) error {
+	params.Set("format", "json")
+	if c.MaxLag > 0 {
+		params.Set("maxlag", strconv.Itoa(c.MaxLag))
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.request(method, params, v)
+		if !IsAPIError(err, "maxlag") || attempt >= c.Retries {
+			return err
+		}
+		c.Sleep(retryAfter)
+	}
+}
+
+// request makes a single request, returning how long the
+// wiki asked us to wait if it turned us away because of lag
+func (c *Client) request(method string, params url.Values, v interface{}) (time.Duration, error) {
+	var req *http.Request
+	var err error
+	if method == http.MethodPost {
+		req, err = http.NewRequest(method, c.APIURL, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequest(method, c.APIURL+"?"+params.Encode(), nil)
+	}
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	// the wiki says how long to wait, but don't hammer it if it doesn't
+	retryAfter := 5 * time.Second
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+
+	// errors are usually sent with 200 OK, but check for one either way
+	var failed struct {
+		Error *APIError `json:"error"`
+	}
+	jsonErr := json.Unmarshal(body, &failed)
+	if jsonErr == nil && failed.Error != nil {
+		return retryAfter, failed.Error
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s from %s", resp.Status, c.APIURL)
+	}
+	if jsonErr != nil {
+		return 0, fmt.Errorf("%s didn't reply with json, is it the path to api.php? (%s)", c.APIURL, jsonErr.Error())
+	}
+
+	return 0, json.Unmarshal(body, v)
+}
@@ -0,0 +1,331 @@
+package mediawiki
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient starts a fake wiki, returning a client for it that
+// records how long it would have slept instead of sleeping
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *[]time.Duration) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL + "/api.php")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var slept []time.Duration
+	client.Sleep = func(d time.Duration) {
+		slept = append(slept, d)
+	}
+	client.Delay = 0
+	return client, &slept
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, code string) {
+	writeJSON(w, map[string]interface{}{
+		"error": map[string]string{"code": code, "info": "fake " + code},
+	})
+}
+
+func writeTokens(w http.ResponseWriter, kind string, token string) {
+	writeJSON(w, map[string]interface{}{
+		"query": map[string]interface{}{
+			"tokens": map[string]string{kind + "token": token},
+		},
+	})
+}
+
+func TestLogin(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("action") {
+		case "query":
+			if r.Form.Get("type") != "login" {
+				t.Errorf("asked for a %q token, expected login", r.Form.Get("type"))
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+			writeTokens(w, "login", "L+\\")
+		case "login":
+			if r.Method != http.MethodPost {
+				t.Errorf("logged in with %s, expected POST", r.Method)
+			}
+			if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "abc" {
+				t.Error("the session cookie from the login token wasn't sent back")
+			}
+			if got := r.Form.Get("lgtoken"); got != "L+\\" {
+				t.Errorf("lgtoken = %q, expected L+\\", got)
+			}
+
+			result := map[string]string{"result": "Success"}
+			if r.Form.Get("lgname") != "Qais@dmlivewiki" || r.Form.Get("lgpassword") != "secret" {
+				result = map[string]string{"result": "Failed", "reason": "Incorrect username or password entered."}
+			}
+			writeJSON(w, map[string]interface{}{"login": result})
+		default:
+			t.Errorf("unexpected action %q", r.Form.Get("action"))
+		}
+	})
+
+	if err := client.Login("Qais@dmlivewiki", "secret"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	err := client.Login("Qais@dmlivewiki", "wrong")
+	if err == nil || !strings.Contains(err.Error(), "Incorrect username or password") {
+		t.Errorf("Login with the wrong password = %v, expected the reason", err)
+	}
+}
+
+func TestEditBadToken(t *testing.T) {
+	tokens := 0
+	edits := 0
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("action") {
+		case "query":
+			tokens++
+			writeTokens(w, "csrf", fmt.Sprintf("token%d+\\", tokens))
+		case "edit":
+			edits++
+			if r.Form.Get("token") != "token2+\\" {
+				writeAPIError(w, "badtoken")
+				return
+			}
+			writeJSON(w, map[string]interface{}{
+				"edit": map[string]interface{}{"result": "Success", "title": "A", "oldrevid": 1, "newrevid": 2},
+			})
+		}
+	})
+
+	result, err := client.Edit(Edit{Title: "A", Text: "text"})
+	if err != nil {
+		t.Fatalf("Edit: %v", err)
+	}
+	if tokens != 2 || edits != 2 {
+		t.Errorf("fetched %d token(s) and made %d edit(s), expected 2 of each", tokens, edits)
+	}
+	if result.NewRevID != 2 {
+		t.Errorf("NewRevID = %d, expected 2", result.NewRevID)
+	}
+
+	// the new token is kept, and a second bad token is given up on
+	if _, err := client.Edit(Edit{Title: "A", Text: "text"}); err != nil || tokens != 2 {
+		t.Errorf("second Edit = %v with %d token(s), expected the token to be reused", err, tokens)
+	}
+	client.csrfToken = "stale"
+	tokens = 5
+	if _, err := client.Edit(Edit{Title: "A", Text: "text"}); !IsAPIError(err, "badtoken") {
+		t.Errorf("Edit with tokens that are always bad = %v, expected badtoken", err)
+	}
+}
+
+func TestMaxLag(t *testing.T) {
+	requests := 0
+	lagged := 2
+	client, slept := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests++
+		if r.Form.Get("maxlag") != "5" {
+			t.Errorf("maxlag = %q, expected 5", r.Form.Get("maxlag"))
+		}
+		if requests <= lagged {
+			w.Header().Set("Retry-After", "3")
+			writeAPIError(w, "maxlag")
+			return
+		}
+		writeTokens(w, "csrf", "token")
+	})
+
+	if _, err := client.token("csrf"); err != nil {
+		t.Fatalf("token: %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("made %d request(s), expected 3", requests)
+	}
+	if len(*slept) != 2 || (*slept)[0] != 3*time.Second || (*slept)[1] != 3*time.Second {
+		t.Errorf("slept %v, expected Retry-After twice", *slept)
+	}
+
+	// give up after Retries
+	requests = 0
+	lagged = 100
+	*slept = nil
+	client.Retries = 2
+	if _, err := client.token("csrf"); !IsAPIError(err, "maxlag") {
+		t.Errorf("token while always lagged = %v, expected maxlag", err)
+	}
+	if requests != 3 || len(*slept) != 2 {
+		t.Errorf("made %d request(s) and slept %d time(s), expected 3 and 2", requests, len(*slept))
+	}
+}
+
+func TestEditDelay(t *testing.T) {
+	client, slept := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("action") == "query" {
+			writeTokens(w, "csrf", "token")
+			return
+		}
+		writeJSON(w, map[string]interface{}{"edit": map[string]interface{}{"result": "Success"}})
+	})
+	client.Delay = 10 * time.Second
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Edit(Edit{Title: "A", Text: "text"}); err != nil {
+			t.Fatalf("Edit: %v", err)
+		}
+	}
+
+	if len(*slept) != 1 {
+		t.Fatalf("slept %v, expected only before the second edit", *slept)
+	}
+	if d := (*slept)[0]; d <= 9*time.Second || d > 10*time.Second {
+		t.Errorf("slept %s before the second edit, expected just under 10s", d)
+	}
+}
+
+func TestEditResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     EditResult
+		wantErr  bool
+	}{
+		{
+			"created",
+			`{"edit":{"result":"Success","title":"A","new":"","oldrevid":0,"newrevid":7}}`,
+			EditResult{Title: "A", Result: "Success", New: true, NewRevID: 7},
+			false,
+		},
+		{
+			"unchanged",
+			`{"edit":{"result":"Success","title":"A","nochange":""}}`,
+			EditResult{Title: "A", Result: "Success", NoChange: true},
+			false,
+		},
+		{
+			"updated",
+			`{"edit":{"result":"Success","title":"A","oldrevid":7,"newrevid":8}}`,
+			EditResult{Title: "A", Result: "Success", OldRevID: 7, NewRevID: 8},
+			false,
+		},
+		{
+			"failed",
+			`{"edit":{"result":"Failure","title":"A"}}`,
+			EditResult{Title: "A", Result: "Failure"},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				if r.Form.Get("action") == "query" {
+					writeTokens(w, "csrf", "token")
+					return
+				}
+				w.Write([]byte(test.response))
+			})
+
+			result, err := client.Edit(Edit{Title: "A", Text: "text"})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Edit error = %v, expected an error: %v", err, test.wantErr)
+			}
+			if result == nil || *result != test.want {
+				t.Errorf("Edit = %+v, expected %+v", result, test.want)
+			}
+		})
+	}
+}
+
+func TestPages(t *testing.T) {
+	requests := 0
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests++
+
+		var normalized []map[string]string
+		var pages []map[string]interface{}
+		for _, title := range strings.Split(r.Form.Get("titles"), "|") {
+			switch {
+			case title == "bad[title]":
+				pages = append(pages, map[string]interface{}{"title": title, "invalid": true})
+			case strings.HasPrefix(title, "missing"):
+				pages = append(pages, map[string]interface{}{"title": title, "missing": true})
+			default:
+				// like the wiki, "a_b" is "A b"
+				tidy := strings.ToUpper(title[:1]) + strings.Replace(title[1:], "_", " ", -1)
+				if tidy != title {
+					normalized = append(normalized, map[string]string{"from": title, "to": tidy})
+				}
+				pages = append(pages, map[string]interface{}{
+					"title": tidy,
+					"revisions": []map[string]interface{}{{
+						"revid":     len(pages) + 1,
+						"timestamp": "2024-01-31T18:00:00Z",
+						"user":      "Volunteer",
+						"slots":     map[string]interface{}{"main": map[string]string{"content": "text of " + tidy}},
+					}},
+				})
+			}
+		}
+
+		writeJSON(w, map[string]interface{}{
+			"query": map[string]interface{}{"normalized": normalized, "pages": pages},
+		})
+	})
+
+	revisions, err := client.Pages([]string{"1990-05-02_Somewhere/Source_1", "missing page", "B"})
+	if err != nil {
+		t.Fatalf("Pages: %v", err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("got %d revision(s), expected 3", len(revisions))
+	}
+
+	first := revisions[0]
+	if first.Missing || first.Title != "1990-05-02 Somewhere/Source 1" || first.Text != "text of 1990-05-02 Somewhere/Source 1" {
+		t.Errorf("normalized page = %+v", first)
+	}
+	if first.User != "Volunteer" || !first.Timestamp.Equal(time.Date(2024, 1, 31, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("normalized page = %+v, expected the user and timestamp", first)
+	}
+	if !revisions[1].Missing || revisions[1].Text != "" {
+		t.Errorf("missing page = %+v", revisions[1])
+	}
+	if revisions[2].Title != "B" || revisions[2].Missing {
+		t.Errorf("page B = %+v", revisions[2])
+	}
+
+	if _, err := client.Pages([]string{"A", "bad[title]"}); err == nil || !strings.Contains(err.Error(), "bad[title]") {
+		t.Errorf("Pages with an invalid title = %v, expected it to be named", err)
+	}
+
+	// titles are asked for 50 at a time
+	requests = 0
+	titles := make([]string, 120)
+	for i := range titles {
+		titles[i] = fmt.Sprintf("Page %d", i)
+	}
+	revisions, err = client.Pages(titles)
+	if err != nil {
+		t.Fatalf("Pages: %v", err)
+	}
+	if requests != 3 || len(revisions) != 120 || revisions[119].Title != "Page 119" {
+		t.Errorf("made %d request(s) for %d page(s), expected 3 for 120 in order", requests, len(revisions))
+	}
+}
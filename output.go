@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	fpath "path/filepath"
+	"sync"
+
+	"github.com/qaisjp/dmlivewiki/util"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// output is how commands change files on disk. In dry run mode
+// nothing is changed, and a plan of what would happen is printed instead.
+type output struct {
+	dryRun bool
+	log    io.Writer  // where plans and removals are reported
+	mu     sync.Mutex // so that plans from parallel albums don't get mixed up
+}
+
+func newOutput(c *cli.Context) *output {
+	return &output{dryRun: c.GlobalBool("dry-run"), log: os.Stdout}
+}
+
+// to returns an output that reports to w instead, such as an album's own log
+func (o *output) to(w io.Writer) *output {
+	return &output{dryRun: o.dryRun, log: w}
+}
+
+// these get a diff in the plan
+var outputTextExtensions = map[string]bool{
+	".txt":  true,
+	".wiki": true,
+}
+
+// writeFile writes data to filename, reporting whether a file was already there
+func (o *output) writeFile(filename string, data []byte) (existed bool, err error) {
+	old, err := ioutil.ReadFile(filename)
+	existed = err == nil
+
+	if !o.dryRun {
+		return existed, ioutil.WriteFile(filename, data, 0666)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	err = nil
+	switch {
+	case !existed:
+		fmt.Fprintf(o.log, "\n[dry run] would create %s\n", filename)
+		old = nil
+	case bytes.Equal(old, data):
+		fmt.Fprintf(o.log, "\n[dry run] would overwrite %s (unchanged)\n", filename)
+		return
+	default:
+		fmt.Fprintf(o.log, "\n[dry run] would overwrite %s\n", filename)
+	}
+
+	if outputTextExtensions[fpath.Ext(filename)] {
+		fromName := filename
+		if !existed {
+			fromName = os.DevNull
+		}
+		fmt.Fprint(o.log, util.UnifiedDiff(fromName, filename, string(old), string(data)))
+	}
+	return
+}
+
+// removeFile deletes filename, returning true if it was removed
+func (o *output) removeFile(filename string, log bool) bool {
+	if !o.dryRun {
+		return util.RemoveFile(o.log, filename, log)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, err := os.Stat(filename); err != nil {
+		if log {
+			fmt.Fprintf(o.log, "\n[dry run] would not delete %s (%s)\n", filename, util.GetFileErrorReason(err))
+		}
+		return false
+	}
+
+	fmt.Fprintf(o.log, "\n[dry run] would delete %s\n", filename)
+	return true
+}
+
+// mkdirAll creates a directory and any parents it needs
+func (o *output) mkdirAll(path string) error {
+	if !o.dryRun {
+		return os.MkdirAll(path, os.ModePerm)
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Fprintf(o.log, "[dry run] would create directory %s\n", path)
+	}
+	return nil
+}
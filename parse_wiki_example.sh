@@ -1,48 +1,30 @@
 #!/bin/bash
 
-# shopt requires bash
+# Imports every page listed in __wikifiles/manifest.json,
+# which has the exact title of each .wiki file. Needs jq.
 
 echo "Started!"
 echo "------"
 
 # First arg to script is the folder of wikifiles
-cd $1
+cd "$1"
 
 apply_to_command (){
 	# first argument is the real filename
-	# second argument is the decoded filename
-	
+	# second argument is the page title
+
 	echo "> $2"
 	echo "From $1"
 	php importTextFiles.php "$1" --title "$2"
 	echo "------"
 }
 
-# Fancy thing to remove "*.wiki" if can't find anything
-# this needs bash
-shopt -s nullglob
-
-# Get all files in current directory
-FILES=*.wiki
-
-for filename in $FILES
+# The filename and title of each page, separated by null characters
+# so that nothing in a title can get in the way
+jq -j '.pages[] | .file, "\u0000", .title, "\u0000"' manifest.json |
+while IFS= read -r -d '' filename && IFS= read -r -d '' title
 do
-	# Replace caret with "\"
-	decoded=${filename//^/\\};
-
-	# Replace pile of poo emoji with ":"
-	decoded=${filename//💩/:}
-
-	# Replace "_" with "/"
-	decoded=${decoded//_/\/};
-
-	# Remove .wiki from title
-	decoded=${decoded/.wiki/};
-
-	# Now actually decode it
-	decoded=$(printf '%s' "$decoded");
-
-	apply_to_command "$filename" "$decoded";
+	apply_to_command "$filename" "$title";
 done
 
 echo "Done!"
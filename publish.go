@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fpath "path/filepath"
+	"text/template"
+
+	"github.com/qaisjp/dmlivewiki/mediawiki"
+	"github.com/qaisjp/dmlivewiki/util"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// The bot password isn't kept in the config, so that the config can be shared
+const botPasswordVariable = "DMLIVEWIKI_BOT_PASSWORD"
+
+func publishWikifiles(c *cli.Context) error {
+	fileInfo, filepath, err := util.CheckFilepathArgument(c)
+	if err != nil {
+		return usageError{err}
+	}
+
+	if c.GlobalBool("delete") {
+		return usageError{errors.New(`"delete" doesn't apply to this commmand`)}
+	}
+
+	dryRun := c.GlobalBool("dry-run")
+	password := os.Getenv(botPasswordVariable)
+	if !dryRun {
+		if config.BotUsername == "" {
+			return usageError{errors.New("botUsername config field missing")}
+		} else if password == "" {
+			return usageError{fmt.Errorf("the bot password has to be in the %s environment variable", botPasswordVariable)}
+		}
+	}
+
+	mode := "batch"
+	if c.GlobalBool("single") {
+		mode = "single"
+	}
+
+	albums, err := findAlbums(c, filepath, fileInfo, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("The following filepath (%s mode) will be published to %s: %s\n", mode, config.APIURL, filepath)
+	printAlbumCount(os.Stdout, albums)
+	util.NotifyDeleteMode(c)
+
+	if !util.ShouldContinue(c) {
+		return nil
+	}
+
+	wikiTemplate, err := loadWikiTemplate()
+	if err != nil {
+		return usageError{err}
+	}
+
+	client, err := mediawiki.NewClient(config.APIURL)
+	if err != nil {
+		return err
+	}
+	client.Delay = c.Duration("delay")
+	client.MaxLag = c.Int("maxlag")
+
+	if !dryRun {
+		fmt.Printf("Logging in to %s as %s... ", config.APIURL, config.BotUsername)
+		if err := client.Login(config.BotUsername, password); err != nil {
+			fmt.Println("failed!")
+			return err
+		}
+		fmt.Println("success!")
+	}
+
+	published, err := loadPublishedRevisions()
+	if err != nil {
+		return err
+	}
+
+	options := publishOptions{
+		client:    client,
+		summary:   c.String("summary"),
+		bot:       c.Bool("bot"),
+		dryRun:    dryRun,
+		published: published,
+	}
+
+	batch := newBatchErrors()
+	for _, album := range albums {
+		batch.add(album.rel, publishWikifile(album.path, album.name, wikiTemplate, options))
+	}
+
+	if !dryRun {
+		if err := published.save(); err != nil {
+			fmt.Println("Could not save which revisions were published:", err.Error())
+		}
+	}
+	return batch.err()
+}
+
+type publishOptions struct {
+	client    *mediawiki.Client
+	summary   string
+	bot       bool
+	dryRun    bool
+	published *publishedRevisions
+}
+
+// publishWikifile renders the wiki page of an album and saves it to the wiki
+func publishWikifile(filepath string, foldername string, wikiTemplate *template.Template, options publishOptions) error {
+	infofile := fpath.Join(filepath, foldername+".txt")
+	fmt.Printf("Publishing from %s... ", infofile)
+
+	info, err := readInfoFile(infofile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("infofile doesn't exist")
+			return errors.New("infofile doesn't exist")
+		}
+		fmt.Printf("parse failure (%s)\n", err.Error())
+		return err
+	}
+
+	title, err := wikiPageTitle(info)
+	if err != nil {
+		fmt.Println("error unescaping query from url")
+		return err
+	}
+	fmt.Printf("\n - %s... ", title)
+
+	page, err := renderWikiPage(filepath, foldername, info, wikiTemplate)
+	if err != nil {
+		return err
+	}
+
+	if options.dryRun {
+		fmt.Printf("\n[dry run] would publish %s (%d bytes)\n", title, len(page))
+		return nil
+	}
+
+	result, err := options.client.Edit(mediawiki.Edit{
+		Title:   title,
+		Text:    string(page),
+		Summary: options.summary,
+		Bot:     options.bot,
+	})
+	if err != nil {
+		fmt.Println("failed!")
+		return err
+	}
+
+	// nothing is saved if the page didn't change, so it keeps the revision it had
+	if result.NewRevID != 0 {
+		options.published.set(title, result.NewRevID, foldername)
+	}
+
+	switch {
+	case result.New:
+		fmt.Println("created!")
+	case result.NoChange:
+		fmt.Println("unchanged.")
+	default:
+		fmt.Println("updated!")
+	}
+	return nil
+}
+
+// publishedRevisions remembers the revision publish saved for every page,
+// so that wiki diff can tell when someone has edited a page on the wiki since,
+// and the album it is from, so that generate knows which sources are taken
+type publishedRevisions struct {
+	filename string
+	Wikis    map[string]map[string]int    `json:"wikis"`  // the api url, to the page title, to the revision
+	Albums   map[string]map[string]string `json:"albums"` // the api url, to the page title, to the album folder name
+}
+
+func loadPublishedRevisions() (*publishedRevisions, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	published := &publishedRevisions{
+		filename: fpath.Join(dir, "dmlivewiki", "published.json"),
+		Wikis:    make(map[string]map[string]int),
+		Albums:   make(map[string]map[string]string),
+	}
+
+	data, err := ioutil.ReadFile(published.filename)
+	if os.IsNotExist(err) {
+		return published, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, published); err != nil {
+		return nil, fmt.Errorf("could not read %s (%s)", published.filename, err.Error())
+	}
+
+	// files from before albums were remembered
+	if published.Albums == nil {
+		published.Albums = make(map[string]map[string]string)
+	}
+	return published, nil
+}
+
+// get returns the revision of a page that was last published to the wiki in the config
+func (p *publishedRevisions) get(title string) (int, bool) {
+	revision, ok := p.Wikis[config.APIURL][title]
+	return revision, ok
+}
+
+func (p *publishedRevisions) set(title string, revision int, album string) {
+	if p.Wikis[config.APIURL] == nil {
+		p.Wikis[config.APIURL] = make(map[string]int)
+	}
+	p.Wikis[config.APIURL][title] = revision
+
+	if p.Albums[config.APIURL] == nil {
+		p.Albums[config.APIURL] = make(map[string]string)
+	}
+	p.Albums[config.APIURL][title] = album
+}
+
+// album returns the folder name of the album a page was published from,
+// or "" if it isn't known
+func (p *publishedRevisions) album(title string) string {
+	return p.Albums[config.APIURL][title]
+}
+
+func (p *publishedRevisions) save() error {
+	data, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(fpath.Dir(p.filename), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p.filename, data, 0666)
+}
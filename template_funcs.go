@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/qaisjp/dmlivewiki/util"
+)
+
+// templateFuncs are available to both the information and wiki templates
+var templateFuncs = template.FuncMap{
+	// {{formatDate "2 January 2006" .Date}}
+	"formatDate": templateFormatDate,
+	"parseDate":  util.ParseDate,
+
+	// {{padIndex 2 .Index}} is "01" for the first track
+	"padIndex": func(width int, index int) string {
+		return fmt.Sprintf("%0*d", width, index)
+	},
+	"add": func(a, b int) int {
+		return a + b
+	},
+
+	// {{len .Tracks}} {{plural (len .Tracks) "track" "tracks"}}
+	"plural": func(n int, singular string, plural string) string {
+		if n == 1 {
+			return singular
+		}
+		return plural
+	},
+
+	// {{join ", " .Names}}
+	"join": func(sep string, list []string) string {
+		return strings.Join(list, sep)
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"title": templateTitle,
+
+	// Durations look like "3:45" or "1:02:03"
+	"parseDuration":  util.ParseDuration,
+	"formatDuration": util.FormatDuration,
+	"addDuration": func(a, b string) (string, error) {
+		return templateDurationArithmetic(a, b, 1)
+	},
+	"subDuration": func(a, b string) (string, error) {
+		return templateDurationArithmetic(a, b, -1)
+	},
+
+	"urlescape":  url.QueryEscape,
+	"wikiescape": util.WikiEscape,
+
+	// {{category (gt (len .Tracks) 20) "Long shows"}}
+	"category": func(condition bool, name string) string {
+		if !condition {
+			return ""
+		}
+		return "[[Category:" + name + "]]"
+	},
+}
+
+// templateTitle upper cases the first letter of every word, where words are only
+// split by spaces, so "it's no good" is "It's No Good" and not "It'S No Good"
+func templateTitle(s string) string {
+	var b strings.Builder
+	start := true
+	for _, r := range s {
+		if start {
+			b.WriteRune(unicode.ToTitle(r))
+		} else {
+			b.WriteRune(r)
+		}
+		start = unicode.IsSpace(r)
+	}
+	return b.String()
+}
+
+func templateFormatDate(layout string, date string) (string, error) {
+	t, err := util.ParseDate(date)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
+
+func templateDurationArithmetic(a, b string, sign time.Duration) (string, error) {
+	x, err := util.ParseDuration(a)
+	if err != nil {
+		return "", err
+	}
+	y, err := util.ParseDuration(b)
+	if err != nil {
+		return "", err
+	}
+	return util.FormatDuration(x + sign*y), nil
+}
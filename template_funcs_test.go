@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestTemplateTitle(t *testing.T) {
+	tests := map[string]string{
+		"":                        "",
+		"it's no good":            "It's No Good",
+		"It's No Good":            "It's No Good",
+		"world in my eyes":        "World In My Eyes",
+		"never let me down again": "Never Let Me Down Again",
+		"one  two\tthree":         "One  Two\tThree",
+		"(live) in berlin":        "(live) In Berlin",
+		"élan":                    "Élan",
+	}
+
+	for in, want := range tests {
+		if got := templateTitle(in); got != want {
+			t.Errorf("templateTitle(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	fpath "path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/qaisjp/dmlivewiki/util"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// loadTemplate parses the template file from the config,
+// or the built in template if there isn't one
+func loadTemplate(name string, filename string, builtin string) (*template.Template, error) {
+	text := builtin
+	if filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s template (%s)", name, err.Error())
+		}
+		text = string(data)
+	}
+
+	t, err := template.New(name).Funcs(templateFuncs).Parse(windowsLineEndings(text))
+	if err != nil {
+		return nil, fmt.Errorf("%s template could not be parsed (%s)", name, err.Error())
+	}
+	return t, nil
+}
+
+// Stupid windows
+func windowsLineEndings(text string) string {
+	return strings.Replace(strings.Replace(text, "\r\n", "\n", -1), "\n", "\r\n", -1)
+}
+
+func loadInformationTemplate() (*template.Template, error) {
+	return loadTemplate("information", config.InfoTemplateFile, defaultInformationTemplate)
+}
+
+func loadWikiTemplate() (*template.Template, error) {
+	return loadTemplate("wiki", config.WikiTemplateFile, defaultWikiTemplate)
+}
+
+// The built in templates, and the names they are dumped as
+var templateFiles = []struct {
+	Filename  string
+	ConfigKey string
+	Text      string
+}{
+	{"info.tmpl", "infoTemplateFile", defaultInformationTemplate},
+	{"wiki.tmpl", "wikiTemplateFile", defaultWikiTemplate},
+}
+
+// dumpTemplates writes the built in templates out, so they can be edited
+func dumpTemplates(c *cli.Context) error {
+	directory := "."
+	if len(c.Args()) > 1 {
+		return newUsageError(c, "expected at most one directory")
+	} else if len(c.Args()) == 1 {
+		directory = c.Args()[0]
+	}
+
+	_, directory, err := util.GetFileOfType(directory, true, "target")
+	if err != nil {
+		return usageError{err}
+	}
+
+	out := newOutput(c)
+	for _, file := range templateFiles {
+		filename := fpath.Join(directory, file.Filename)
+		if _, err := os.Stat(filename); err == nil && !c.GlobalBool("force") {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", filename)
+		}
+
+		if _, err := out.writeFile(filename, []byte(file.Text)); err != nil {
+			return err
+		}
+		fmt.Println("Wrote", filename)
+	}
+
+	fmt.Println("\nTo use them, add the following to your config:")
+	for _, file := range templateFiles {
+		fmt.Printf("%s: %q\n", file.ConfigKey, fpath.Join(directory, file.Filename))
+	}
+	return nil
+}
+
+// Information template to write .txt info files from a folder
+const defaultInformationTemplate = `{{.Artist}}
+{{.Date}}
+{{.Album}}
+{{.Tour}}
+
+Lineage: 
+
+Notes: 
+
+This source is considered Source {{.Source}} for this date:
+{{.Config.WikiPath}}/{{wikiescape .Date}}_{{wikiescape .Album}}/Source_{{.Source}}
+
+Track list:
+
+{{range .Tracks}}{{.Prefix}}{{printf "%02d" .Index}}. [{{.Duration}}] {{.Title}}{{range .Annotations}} {{.Symbol}}{{end}}
+{{end}}Total time: {{.Duration}}
+
+{{range .Annotations}}{{.Symbol}} {{.Legend}}
+{{end}}{{if .Annotations}}
+{{end}}{{.Config.Footer}}`
+
+// Wiki template to write the .wiki files from edited .txt info files
+const defaultWikiTemplate = `== Notes ==
+
+{{.Notes}}
+
+== Listen ==
+
+You can listen to this entire recording below.
+
+<html5media>{{.Config.StreamPath}}/{{.FolderName}}/complete.m4a</html5media>
+
+== Track list ==
+
+{{range .Tracks}}{{.LinePrefix}}[{{.Duration}}] <sm2>{{$.Config.StreamPath}}/{{.FolderName}}/{{printf "%02d" .Index}}.m4a</sm2> [[{{.Name}}]]{{range .Annotations}} {{"{{"}}tt|{{.Symbol}}|{{.Tooltip}}{{"}}"}}{{end}}
+{{end}}*Total time: {{.Duration}}
+
+== Lineage ==
+
+{{.Lineage}}
+== Download ==
+
+*[{{.Config.DownloadPath}}/{{.FolderName}}.zip Download ZIP] - FLAC {{.BPS}}-bit {{.SampleRate}} - {{.Size}}
+
+[[Category:Audience recordings]]
+[[Category:Source]]
+[[Category:Streamable]]
+`
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fpath "path/filepath"
+	"strings"
+	"time"
+
+	"github.com/qaisjp/dmlivewiki/util"
+	"gopkg.in/urfave/cli.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// TourDatabase is everything in a tours.yaml file
+type TourDatabase struct {
+	LeadVocalist string  `yaml:"leadVocalist,omitempty"` // songs sung by anyone else are marked, defaults to Dave Gahan
+	Tours        []*Tour `yaml:"tours"`
+}
+
+type Tour struct {
+	Name    string              `yaml:"name"`
+	Start   string              `yaml:"start,omitempty"` // dates look like "1990-05-02"
+	End     string              `yaml:"end,omitempty"`
+	Legs    []TourLeg           `yaml:"legs,omitempty"`
+	Setlist []string            `yaml:"setlist,omitempty"`
+	Aliases map[string]string   `yaml:"aliases,omitempty"` // other titles a song is tagged with, to the real title
+	Songs   map[string]TourSong `yaml:"songs,omitempty"`
+
+	leadVocalist string
+}
+
+type TourLeg struct {
+	Name  string `yaml:"name"`
+	Start string `yaml:"start,omitempty"`
+	End   string `yaml:"end,omitempty"`
+}
+
+// TourSong is what is special about a song on a tour
+type TourSong struct {
+	Vocalist string   `yaml:"vocalist,omitempty"`
+	Tags     []string `yaml:"tags,omitempty"`  // like "acoustic", for annotations to match
+	Debut    string   `yaml:"debut,omitempty"` // the date the song was first played
+}
+
+const defaultLeadVocalist = "Dave Gahan"
+
+// the old tourfile format could only say that someone other than Dave sang
+const tourFileVocalist = "Martin Gore"
+
+// loadTourDatabase reads a tours.yaml file, or a tourfile in the old text format
+func loadTourDatabase(filepath string) (*TourDatabase, error) {
+	var db *TourDatabase
+	var err error
+
+	switch strings.ToLower(fpath.Ext(filepath)) {
+	case ".yaml", ".yml":
+		db, err = readTourYAML(filepath)
+	default:
+		db, err = readTourFile(filepath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if db.LeadVocalist == "" {
+		db.LeadVocalist = defaultLeadVocalist
+	}
+	for _, tour := range db.Tours {
+		tour.leadVocalist = db.LeadVocalist
+	}
+	return db, nil
+}
+
+func readTourYAML(filepath string) (*TourDatabase, error) {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, errors.New("Could not open Tourfile (" + err.Error() + ")")
+	}
+
+	db := new(TourDatabase)
+	if err := yaml.UnmarshalStrict(data, db); err != nil {
+		return nil, fmt.Errorf("Could not read Tourfile (%s)", err.Error())
+	}
+
+	for i, tour := range db.Tours {
+		if tour.Name == "" {
+			return nil, fmt.Errorf("tour %d in Tourfile has no name", i+1)
+		}
+		if err := checkTourDates(tour.Name, tour.Start, tour.End); err != nil {
+			return nil, err
+		}
+		for title, song := range tour.Songs {
+			if err := checkTourDates(tour.Name+" ("+title+" debut)", song.Debut, ""); err != nil {
+				return nil, err
+			}
+		}
+		for _, leg := range tour.Legs {
+			if err := checkTourDates(tour.Name+" ("+leg.Name+")", leg.Start, leg.End); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return db, nil
+}
+
+func checkTourDates(name string, start string, end string) error {
+	for _, date := range []string{start, end} {
+		if date == "" {
+			continue
+		}
+		if _, err := util.ParseDate(date); err != nil {
+			return fmt.Errorf("%s in Tourfile: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+// readTourFile reads the old format, with lines like
+// "2023 Memento Mori Tour: A Question Of Lust, Soul With Me"
+func readTourFile(filepath string) (*TourDatabase, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, errors.New("Could not open Tourfile (" + err.Error() + ")")
+	}
+	defer file.Close()
+
+	db := new(TourDatabase)
+
+	reader := bufio.NewReader(file)
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		separator := strings.Index(line, ":")
+		if separator == -1 {
+			continue
+		}
+
+		tour := &Tour{
+			Name:  strings.TrimSpace(line[:separator]),
+			Songs: make(map[string]TourSong),
+		}
+
+		list := strings.TrimSpace(line[separator+1:])
+		for _, track := range strings.Split(list, ",") {
+			if track = strings.TrimSpace(track); track != "" {
+				tour.Songs[track] = TourSong{Vocalist: tourFileVocalist}
+			}
+		}
+
+		db.Tours = append(db.Tours, tour)
+	}
+	return db, scanner.Err()
+}
+
+func (db *TourDatabase) find(name string) (*Tour, bool) {
+	for _, tour := range db.Tours {
+		if strings.EqualFold(tour.Name, name) {
+			return tour, true
+		}
+	}
+	return nil, false
+}
+
+// song looks up a song by its title, or any of its aliases
+func (t *Tour) song(title string) (TourSong, bool) {
+	if real, ok := t.Aliases[title]; ok {
+		title = real
+	}
+	song, ok := t.Songs[title]
+	return song, ok
+}
+
+func (s TourSong) hasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *TourDatabase) hasDates() bool {
+	for _, tour := range db.Tours {
+		if tour.hasDates() {
+			return true
+		}
+	}
+	return false
+}
+
+// toursOn returns every tour that was on the road on a date
+func (db *TourDatabase) toursOn(date string) ([]*Tour, error) {
+	day, err := util.ParseDate(date)
+	if err != nil {
+		return nil, err
+	}
+
+	var tours []*Tour
+	for _, tour := range db.Tours {
+		if tour.playedOn(day) {
+			tours = append(tours, tour)
+		}
+	}
+	return tours, nil
+}
+
+func (t *Tour) hasDates() bool {
+	if t.Start != "" && t.End != "" {
+		return true
+	}
+	for _, leg := range t.Legs {
+		if leg.Start != "" && leg.End != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// playedOn reports whether the day is between the start and end of the tour, or of one of its legs
+func (t *Tour) playedOn(day time.Time) bool {
+	if dateBetween(day, t.Start, t.End) {
+		return true
+	}
+	for _, leg := range t.Legs {
+		if dateBetween(day, leg.Start, leg.End) {
+			return true
+		}
+	}
+	return false
+}
+
+func dateBetween(day time.Time, start string, end string) bool {
+	if start == "" || end == "" {
+		return false
+	}
+
+	// these have already been checked when the file was loaded
+	from, _ := util.ParseDate(start)
+	to, _ := util.ParseDate(end)
+
+	// "1990-11" and "1990" end on the last day of the month or year, not the first
+	switch len(strings.TrimSpace(end)) {
+	case len("2006-01"):
+		to = to.AddDate(0, 1, -1)
+	case len("2006"):
+		to = to.AddDate(1, 0, -1)
+	}
+	return !day.Before(from) && !day.After(to)
+}
+
+// convertTourFile turns an old tourfile into a tours.yaml file
+func convertTourFile(c *cli.Context) error {
+	if len(c.Args()) < 1 || len(c.Args()) > 2 {
+		return newUsageError(c, "expected a tourfile, and optionally where to write the yaml file")
+	}
+
+	_, input, err := util.GetFileOfType(c.Args()[0], false, "tourfile")
+	if err != nil {
+		return usageError{err}
+	}
+
+	output := strings.TrimSuffix(input, fpath.Ext(input)) + ".yaml"
+	if len(c.Args()) == 2 {
+		output = c.Args()[1]
+	}
+
+	db, err := readTourFile(input)
+	if err != nil {
+		return err
+	}
+	if len(db.Tours) == 0 {
+		return fmt.Errorf("no tours found in %s", input)
+	}
+
+	data, err := yaml.Marshal(db)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(output); err == nil && !c.GlobalBool("force") {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", output)
+	}
+
+	header := "# Converted from " + fpath.Base(input) + ". Add start and end dates, legs,\n" +
+		"# setlists and aliases to each tour as needed.\n"
+	if _, err := newOutput(c).writeFile(output, append([]byte(header), data...)); err != nil {
+		return err
+	}
+
+	fmt.Printf("Converted %d tours to %s\n", len(db.Tours), output)
+	return nil
+}
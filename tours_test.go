@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateBetween(t *testing.T) {
+	tests := []struct {
+		day   string
+		start string
+		end   string
+		want  bool
+	}{
+		{"1990-05-28", "1990-05-28", "1990-11-27", true},
+		{"1990-11-27", "1990-05-28", "1990-11-27", true},
+		{"1990-11-28", "1990-05-28", "1990-11-27", false},
+		{"1990-05-27", "1990-05-28", "1990-11-27", false},
+		{"1990-11-20", "1990-05", "1990-11", true},
+		{"1990-11-30", "1990-05", "1990-11", true},
+		{"1990-12-01", "1990-05", "1990-11", false},
+		{"1990-05-01", "1990-05", "1990-11", true},
+		{"1990-04-30", "1990-05", "1990-11", false},
+		{"1990-12-31", "1990", "1990", true},
+		{"1991-01-01", "1990", "1990", false},
+		{"1990-02-28", "1990-02", "1990-02", true},
+		{"1990-05-28", "", "1990-11-27", false},
+	}
+
+	for _, test := range tests {
+		day, err := time.Parse("2006-01-02", test.day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := dateBetween(day, test.start, test.end); got != test.want {
+			t.Errorf("dateBetween(%s, %q, %q) = %v, expected %v", test.day, test.start, test.end, got, test.want)
+		}
+	}
+}
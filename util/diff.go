@@ -0,0 +1,140 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffLine is a single line of an edit script
+type diffLine struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// UnifiedDiff returns a unified diff turning a into b, or "" if they are the same.
+// Files are small, so the simple longest common subsequence table is good enough.
+func UnifiedDiff(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	const context = 3
+	for start := 0; start < len(lines); {
+		// find the next change
+		first := start
+		for first < len(lines) && lines[first].kind == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+
+		// keep going until there's enough unchanged lines to end the hunk
+		last := first
+		for i := first; i < len(lines) && i <= last+2*context; i++ {
+			if lines[i].kind != ' ' {
+				last = i
+			}
+		}
+
+		from := first - context
+		if from < start {
+			from = start
+		}
+		if from < 0 {
+			from = 0
+		}
+		to := last + context + 1
+		if to > len(lines) {
+			to = len(lines)
+		}
+
+		writeHunk(&out, lines, from, to)
+		start = to
+	}
+
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, lines []diffLine, from, to int) {
+	// count the lines of each file before and inside the hunk
+	var aStart, bStart, aCount, bCount int
+	for i, line := range lines[:to] {
+		inHunk := i >= from
+		if line.kind != '+' {
+			if inHunk {
+				aCount++
+			} else {
+				aStart++
+			}
+		}
+		if line.kind != '-' {
+			if inHunk {
+				bCount++
+			} else {
+				bStart++
+			}
+		}
+	}
+	if aCount > 0 {
+		aStart++
+	}
+	if bCount > 0 {
+		bStart++
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+	for _, line := range lines[from:to] {
+		fmt.Fprintf(out, "%c%s\n", line.kind, line.text)
+	}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func diffLines(a, b []string) []diffLine {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// "\r" is left out so that diffs of windows files print nicely
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', strings.TrimSuffix(a[i], "\r")})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', strings.TrimSuffix(a[i], "\r")})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', strings.TrimSuffix(b[j], "\r")})
+			j++
+		}
+	}
+	return lines
+}
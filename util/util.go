@@ -1,11 +1,14 @@
 package util
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
 	fpath "path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,45 +19,31 @@ func WikiEscape(s string) string {
 	return url.QueryEscape(strings.Replace(s, " ", "_", -1))
 }
 
-// not needed anymore
-func CreateFile(filename string) *os.File {
-	f, err := os.Create(filename)
-	if err != nil {
-		if os.IsPermission(err) {
-			fmt.Println("permission error!")
-			return nil
-		}
-
-		panic(err.Error())
-	}
-	return f
-}
-
 // a bit of a mess
-func RemoveFile(filename string, log bool) bool {
+func RemoveFile(w io.Writer, filename string, log bool) bool {
 	if log {
-		fmt.Printf("Removing %s...", filename)
+		fmt.Fprintf(w, "Removing %s...", filename)
 	}
 
 	err := os.Remove(filename)
 	if err != nil {
 		if os.IsNotExist(err) {
 			if log {
-				fmt.Println(" does not exist!")
+				fmt.Fprintln(w, " does not exist!")
 			}
 			return false
 		} else if os.IsPermission(err) {
 			if log {
-				fmt.Println(" permission error!")
+				fmt.Fprintln(w, " permission error!")
 			}
 			return false
 		}
-		fmt.Println("Something happened when deleting your file! :(")
-		fmt.Println(err.Error())
+		fmt.Fprintln(w, "Something happened when deleting your file! :(")
+		fmt.Fprintln(w, err.Error())
 		return false
 	}
 	if log {
-		fmt.Println(" success!")
+		fmt.Fprintln(w, " success!")
 	}
 	return true
 }
@@ -99,56 +88,56 @@ func GetFileErrorReason(err error) string {
 	}
 }
 
-func CheckFilepathArgument(c *cli.Context) (os.FileInfo, string) {
+func CheckFilepathArgument(c *cli.Context) (os.FileInfo, string, error) {
 	if len(c.Args()) != 1 {
 		if err := cli.ShowSubcommandHelp(c); err != nil {
 			fmt.Println("Error:", err.Error())
 		}
-		return nil, ""
+		return nil, "", errors.New("expected exactly one directory")
 	}
 
 	filepath := c.Args()[0]
 	return GetFileOfType(filepath, true, "target")
 }
 
-func GetFileOfType(filepath string, wantDirectory bool, target string) (os.FileInfo, string) {
+func GetFileOfType(filepath string, wantDirectory bool, target string) (os.FileInfo, string, error) {
 	filepath, err := fpath.Abs(filepath)
 	if err != nil {
-		fmt.Println("Could not find absolute directory for path. Error:")
-		fmt.Println(err.Error())
-		return nil, ""
+		return nil, "", fmt.Errorf("could not find absolute directory for %s (%s)", target, err.Error())
 	}
 
 	isDirectory, fileInfo, _ := isDirectory(filepath)
 	if (fileInfo == nil) || (isDirectory != wantDirectory) {
 		if wantDirectory {
-			fmt.Println("Error:", target, "is not a directory")
-		} else {
-			fmt.Println("Error:", target, "is not a file")
+			return nil, "", fmt.Errorf("%s is not a directory", target)
 		}
-		return nil, ""
+		return nil, "", fmt.Errorf("%s is not a file", target)
 	}
 
-	return fileInfo, filepath
+	return fileInfo, filepath, nil
 }
 
+// The prompt goes to stderr so that reports written to stdout stay clean
 func ShouldContinue(c *cli.Context) bool {
 	// Ask to continue or just process?
-	if c.GlobalBool("force") {
-		fmt.Print("\n")
+	// a dry run doesn't change anything, so there's nothing to confirm
+	if c.GlobalBool("force") || c.GlobalBool("dry-run") {
+		fmt.Fprint(os.Stderr, "\n")
 		return true
 	}
 
-	fmt.Print("Continue? (y/n): ")
+	fmt.Fprint(os.Stderr, "Continue? (y/n): ")
 	text := ""
 	fmt.Scanln(&text)
-	fmt.Print("\n")
+	fmt.Fprint(os.Stderr, "\n")
 
 	return text == "y" || text == "Y"
 }
 
 func NotifyDeleteMode(c *cli.Context) {
-	if c.GlobalBool("delete") {
+	if c.GlobalBool("dry-run") {
+		fmt.Println("You are running in dry run mode - nothing will be written or deleted")
+	} else if c.GlobalBool("delete") {
 		fmt.Println("You are running in **DELETE MODE** - data will be permanently lost")
 	}
 }
@@ -170,3 +159,33 @@ func FormatDuration(d time.Duration) (str string) {
 	}
 	return
 }
+
+// ParseDuration reads durations written by FormatDuration
+func ParseDuration(str string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(str), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("could not understand duration %q", str)
+	}
+
+	var d time.Duration
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("could not understand duration %q", str)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	return d * time.Second, nil
+}
+
+// Dates are usually read from the date tag, which looks like "1990-05-02"
+var dateLayouts = []string{"2006-01-02", "2006.01.02", "2006/01/02", "2006-01", "2006"}
+
+func ParseDate(date string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(date)); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not understand date %q", date)
+}
@@ -2,15 +2,15 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"crypto/md5"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
-	"os/exec"
 	fpath "path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/qaisjp/dmlivewiki/flac"
 	"github.com/qaisjp/dmlivewiki/util"
 
 	"gopkg.in/urfave/cli.v1"
@@ -18,15 +18,75 @@ import (
 
 var tick, cross string = `ok`, `bad`
 
-func verifyChecksum(c *cli.Context) {
-	fileInfo, filepath := util.CheckFilepathArgument(c)
-	if fileInfo == nil {
-		return
+// Statuses for a verifyFile
+const (
+	verifyOK        = "ok"
+	verifyMismatch  = "mismatch"
+	verifyMissing   = "missing"
+	verifyUntracked = "untracked"
+	verifyError     = "error"
+)
+
+// verifyFile is the result of checking one file against one algorithm
+type verifyFile struct {
+	File      string `json:"file"`
+	Algorithm string `json:"algorithm"` // a manifest algorithm, "ffp", or "audio" for --deep
+	Expected  string `json:"expected,omitempty"`
+	Actual    string `json:"actual,omitempty"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// verifyCheck is the overall result of one manifest (or the ffp) for an album
+type verifyCheck struct {
+	Algorithm string `json:"algorithm"`
+	Status    string `json:"status"`
+}
+
+// verifyAlbum is every result for a single album
+type verifyAlbum struct {
+	Name   string        `json:"name"`
+	Path   string        `json:"path"`
+	Status string        `json:"status"`
+	Checks []verifyCheck `json:"checks"`
+	Errors []string      `json:"errors,omitempty"` // problems not tied to a single file
+	Files  []verifyFile  `json:"files"`
+}
+
+func (a *verifyAlbum) addFile(file verifyFile) {
+	a.Files = append(a.Files, file)
+}
+
+func (a *verifyAlbum) addError(format string, args ...interface{}) {
+	a.Errors = append(a.Errors, fmt.Sprintf(format, args...))
+}
+
+func (a *verifyAlbum) addCheck(algorithm string, success bool) {
+	status := verifyOK
+	if !success {
+		status = verifyError
+	}
+	a.Checks = append(a.Checks, verifyCheck{algorithm, status})
+}
+
+func (a *verifyAlbum) ok() bool {
+	return a.Status == verifyOK
+}
+
+func verifyChecksum(c *cli.Context) error {
+	fileInfo, filepath, err := util.CheckFilepathArgument(c)
+	if err != nil {
+		return usageError{err}
 	}
 
 	if c.GlobalBool("delete") {
-		fmt.Println(`"delete" doesn't apply to this commmand`)
-		return
+		return usageError{errors.New(`"delete" doesn't apply to this commmand`)}
+	}
+
+	format := c.String("format")
+	writer, err := newVerifyWriter(format, os.Stdout)
+	if err != nil {
+		return newUsageError(c, "%s", err.Error())
 	}
 
 	mode := "batch"
@@ -34,89 +94,155 @@ func verifyChecksum(c *cli.Context) {
 		mode = "single"
 	}
 
-	fmt.Printf("The following filepath (%s mode) will be processed: %s\n", mode, filepath)
-	util.NotifyDeleteMode(c)
+	// Keep stdout clean for machine readable reports
+	var info io.Writer = os.Stdout
+	if format != "text" {
+		info = os.Stderr
+	}
+
+	albums, err := findAlbums(c, filepath, fileInfo, info)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(info, "The following filepath (%s mode) will be processed: %s\n", mode, filepath)
+	printAlbumCount(info, albums)
 
 	if !util.ShouldContinue(c) {
-		return
+		return nil
 	}
 
-	// We need to get the working directory
-	// so that we can get the path to metaflac
-	workingDirectory, err := os.Getwd()
-	if err != nil {
-		fmt.Println("could not get working directory for some reason")
-		fmt.Println("reason is: " + err.Error())
-		fmt.Println("aborting!")
-		return
+	cache := loadChecksumCache(info)
+
+	batch := newBatchErrors()
+	for _, album := range albums {
+		result := verifyProcessPath(album.path, album.name, c.Bool("deep"), cache, c.Bool("quick"))
+		if result.ok() {
+			batch.add(album.rel, nil)
+		} else {
+			batch.add(album.rel, errors.New("failed verification"))
+		}
+		writer.Album(result)
 	}
 
-	if mode == "single" {
-		verifyProcessPath(filepath, fileInfo.Name(), workingDirectory)
-		return
+	if err := writer.Finish(); err != nil {
+		return fmt.Errorf("could not write report: %w", err)
+	}
+
+	if hits := cache.cacheHits(); hits > 0 {
+		fmt.Fprintf(info, "%d unchanged file(s) weren't hashed again, leave out --quick to hash everything\n", hits)
 	}
 
-	files, _ := ioutil.ReadDir(filepath)
-	for _, file := range files {
-		if file.IsDir() && file.Name() != "__wikifiles" {
-			verifyProcessPath(fpath.Join(filepath, file.Name()), file.Name(), workingDirectory)
+	// hashes found by a full verify make the next quick one quicker
+	if !c.GlobalBool("dry-run") {
+		if err := cache.save(); err != nil {
+			fmt.Fprintln(info, "Could not save the checksum cache:", err.Error())
 		}
 	}
+
+	return batch.err()
 }
 
-func verifyProcessPath(directory string, name string, workingDirectory string) {
-	// Let us know what is currently being processed
-	fmt.Print(directory + "... ")
+func verifyProcessPath(directory string, name string, deep bool, cache *checksumCache, quick bool) *verifyAlbum {
+	album := &verifyAlbum{
+		Name: name,
+		Path: directory,
+	}
 
 	baseFilename := fpath.Join(directory, name+".")
 	ffpFilename := baseFilename + "ffp"
-	md5Filename := baseFilename + "md5"
 
 	_, ffpErr := os.Stat(ffpFilename)
-	_, md5Err := os.Stat(md5Filename)
-	for _, err := range []error{ffpErr, md5Err} {
-		if err != nil {
-			file := "ffp"
-			if err == md5Err {
-				file = "md5"
-			}
-			fmt.Printf("\n> %s read error: (%s)", file, util.GetFileErrorReason(err))
+	if ffpErr != nil {
+		album.addError("ffp read error: (%s)", util.GetFileErrorReason(ffpErr))
+	}
+
+	// Check every kind of manifest that has been written for this album
+	var manifests []checksumAlgorithm
+	for _, algo := range checksumAlgorithms {
+		_, err := os.Stat(baseFilename + algo.Name)
+		if err == nil {
+			manifests = append(manifests, algo)
+		} else if !os.IsNotExist(err) {
+			album.addError("%s read error: (%s)", algo.Name, util.GetFileErrorReason(err))
 		}
 	}
 
-	md5Success, md5ReadError := false, false
-	if md5Err == nil {
-		md5Success, md5ReadError = verifyMD5(md5Filename, directory)
+	if len(manifests) == 0 {
+		album.addError("no checksum manifests found (looked for %s)", checksumAlgorithmNames())
+	}
+
+	// every file listed by a manifest
+	tracked := make(map[string]bool)
+
+	manifestSuccess := make([]bool, len(manifests))
+	manifestReadError := false
+	for i, algo := range manifests {
+		success, readError := verifyManifest(baseFilename+algo.Name, directory, algo, album, tracked, cache, quick)
+		manifestSuccess[i] = success
+		manifestReadError = manifestReadError || readError
 	}
 
 	ffpSuccess := false
-	if md5ReadError {
-		fmt.Printf("\n> skipping ffp check because of md5 file errors")
+	if manifestReadError {
+		album.addError("skipping ffp check because of manifest file errors")
 	} else if ffpErr == nil {
-		ffpSuccess = verifyFFP(ffpFilename, directory, workingDirectory)
+		ffpSuccess = verifyFFP(ffpFilename, directory, deep, album)
 	}
 
-	if md5Success && ffpSuccess {
-		fmt.Println(tick)
-		return
+	album.addCheck("ffp", ffpSuccess)
+	for i, algo := range manifests {
+		album.addCheck(algo.Name, manifestSuccess[i])
 	}
 
-	md5out, ffpOut := cross, cross
-	if md5Success {
-		md5out = tick
+	// Anything on disk that no manifest knows about is untracked
+	untracked := false
+	if len(manifests) > 0 {
+		files, err := checksumListFiles(directory, name)
+		if err != nil {
+			album.addError("%s", err.Error())
+			untracked = true
+		}
+
+		for _, file := range files {
+			if !tracked[file] && !verifyIsIgnored(file) {
+				album.addFile(verifyFile{File: file, Status: verifyUntracked})
+				untracked = true
+			}
+		}
 	}
-	if ffpSuccess {
-		ffpOut = tick
+
+	album.Status = verifyOK
+	if !ffpSuccess || len(manifests) == 0 || untracked {
+		album.Status = verifyError
+	}
+	for _, success := range manifestSuccess {
+		if !success {
+			album.Status = verifyError
+		}
 	}
 
-	fmt.Printf("\n> done! ffp(%s) md5(%s)\n\n", ffpOut, md5out)
+	return album
 }
 
-// verify an md5 file against a directory
-func verifyMD5(md5Filename string, directory string) (success, readError bool) {
-	file, err := os.Open(md5Filename)
+// verifyIsIgnored reports whether an untracked file is harmless junk
+func verifyIsIgnored(filename string) bool {
+	for _, pattern := range config.VerifyIgnore {
+		if ok, _ := fpath.Match(pattern, fpath.Base(filename)); ok {
+			return true
+		}
+		if ok, _ := fpath.Match(pattern, filename); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// verify a checksum manifest against a directory
+func verifyManifest(manifestFilename string, directory string, algo checksumAlgorithm, album *verifyAlbum, tracked map[string]bool, cache *checksumCache, quick bool) (success, readError bool) {
+	file, err := os.Open(manifestFilename)
 	if err != nil {
-		fmt.Printf("\n> md5: read err (%s)", err.Error())
+		album.addError("%s: read err (%s)", algo.Name, err.Error())
 
 		// we won't return readError at true because that's intended
 		// for individual file read errors! this should be clearer
@@ -128,50 +254,70 @@ func verifyMD5(md5Filename string, directory string) (success, readError bool) {
 	scanner := bufio.NewScanner(reader)
 	success = true
 
+	hexLength := algo.hexLength()
+	algos := []checksumAlgorithm{algo}
+
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		checksum := line[:32]
-		filename := line[34:]
-
-		// Read the file
-		data, err := ioutil.ReadFile(fpath.Join(directory, filename))
-		if err != nil {
-			fmt.Printf("\n> md5: read error with %s (%s)", filename, util.GetFileErrorReason(err))
+		// Lines look like "<hash> *<filename>"
+		if len(line) < hexLength+3 || line[hexLength:hexLength+2] != " *" {
+			album.addError("%s: incorrect line format\n>> content (len:%d): %s", algo.Name, len(line), line)
 			success = false
-			readError = true
+			continue
 		}
 
-		if fmt.Sprintf("%x", md5.Sum(data)) != checksum {
-			fmt.Printf("\n> md5: mismatch for \"%s\"", filename)
-			success = false
+		result := verifyFile{
+			File:      line[hexLength+2:],
+			Algorithm: algo.Name,
+			Expected:  strings.ToLower(line[:hexLength]),
+			Status:    verifyOK,
+		}
+		tracked[fpath.FromSlash(result.File)] = true
+
+		// Hash the file
+		sums, err := cache.hashFile(fpath.Join(directory, result.File), algos, quick)
+		if os.IsNotExist(err) {
+			result.Status = verifyMissing
+			result.Reason = util.GetFileErrorReason(err)
+		} else if err != nil {
+			result.Status = verifyError
+			result.Reason = util.GetFileErrorReason(err)
+			readError = true
+		} else {
+			result.Actual = fmt.Sprintf("%x", sums[0])
+			if result.Actual != result.Expected {
+				result.Status = verifyMismatch
+			}
 		}
+
+		success = success && result.Status == verifyOK
+		album.addFile(result)
 	}
 	return
 }
 
-// verify an ffp file against a directory
-func verifyFFP(ffpFilename string, directory string, workingDirectory string) (success bool) {
+// verify an ffp file against a directory, decoding
+// all of the audio if a deep check is requested
+func verifyFFP(ffpFilename string, directory string, deep bool, album *verifyAlbum) (success bool) {
 	file, err := os.Open(ffpFilename)
 	if err != nil {
-		fmt.Printf("\n> ffp: read err (%s)", err.Error())
+		album.addError("ffp: read err (%s)", err.Error())
 		return
 	}
 	defer file.Close()
 
-	var ffpFileBuffer bytes.Buffer // contains actual ffp content
 	var files, checksums []string
+	success = true
 
 	reader := bufio.NewReader(file)
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
-		ffpFileBuffer.WriteString(line + "\r\n")
 
 		// The line has to be atleast 34 characters long
 		if len(line) < 34 {
-			fmt.Print("\n> ffp: incorrect line format")
-			fmt.Printf("\n>> content (len:%d): %s", len(line), line)
+			album.addError("ffp: incorrect line format\n>> content (len:%d): %s", len(line), line)
 			continue
 		}
 
@@ -181,86 +327,101 @@ func verifyFFP(ffpFilename string, directory string, workingDirectory string) (s
 		if err == nil {
 			files = append(files, filename)
 			checksums = append(checksums, checksum)
-		} else {
-			fmt.Printf("\n> ffp: \"%s\" has a problem (%s)", filename, util.GetFileErrorReason(err))
+			continue
+		}
+
+		status := verifyError
+		if os.IsNotExist(err) {
+			status = verifyMissing
 		}
+		album.addFile(verifyFile{
+			File:      filename,
+			Algorithm: "ffp",
+			Expected:  checksum,
+			Status:    status,
+			Reason:    util.GetFileErrorReason(err),
+		})
+		success = false
 	}
 
 	if len(files) == 0 {
-		fmt.Print("\n> ffp file contains no valid flac files")
-		return
+		album.addError("ffp file contains no valid flac files")
+		return false
 	}
 
-	var cmdStdout, cmdStderr bytes.Buffer
-	cmdArgs := append(
-		[]string{
-			"--show-md5sum",
-		},
-		files[:]...,
-	)
+	for i, filename := range files {
+		result := verifyFile{
+			File:      filename,
+			Algorithm: "ffp",
+			Expected:  checksums[i],
+			Status:    verifyOK,
+		}
 
-	cmd := exec.Command(metaflacPath, cmdArgs[:]...)
-	cmd.Dir = directory
-	cmd.Stderr = &cmdStderr
-	cmd.Stdout = &cmdStdout
+		meta, err := flac.ReadFile(fpath.Join(directory, filename))
+		if err != nil {
+			result.Status = verifyError
+			result.Reason = err.Error()
+		} else {
+			result.Actual = meta.StreamInfo.MD5String()
+			if result.Actual != result.Expected {
+				result.Status = verifyMismatch
+			}
+		}
 
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("\n> ffp metaflac error (%s)", err.Error())
-	}
-
-	if cmdStderr.Len() != 0 {
-		fmt.Print("\n> ffp metaflac returned error info, dumping output: \n\t",
-			// Replace every new line of the stderr with an indentation
-			strings.TrimSpace(strings.Replace(
-				cmdStderr.String(),
-				"\r\n",
-				"\n\t",
-				-1,
-			)),
-		)
-
-		if cmdStdout.Len() == 0 {
-			return
+		success = success && result.Status == verifyOK
+		album.addFile(result)
+
+		if deep && !verifyAudio(fpath.Join(directory, filename), filename, album) {
+			success = false
 		}
 	}
 
-	success = true
+	return
+}
 
-	// first do a quick equality check
-	if bytes.Equal(cmdStdout.Bytes(), ffpFileBuffer.Bytes()) {
-		// we don't need to check line by line
-		return
+// decode a flac file and check the audio against its stored signature
+func verifyAudio(filepath string, filename string, album *verifyAlbum) bool {
+	result := verifyFile{
+		File:      filename,
+		Algorithm: "audio",
+		Status:    verifyOK,
 	}
 
-	// some things failed, so now we need to go through each one
-	stdoutScanner := bufio.NewScanner(bufio.NewReader(&cmdStdout))
-	for stdoutScanner.Scan() {
-		line := stdoutScanner.Text()
-
-		for i, file := range files {
-			filename, checksum := verifyReadFFP(line)
-			if filename == file {
-				if checksum != checksums[i] {
-					fmt.Printf("\n> ffp: mismatch for \"%s\"", filename)
-					success = false
-				}
+	check, err := flac.CheckAudioFile(filepath)
+	if check != nil {
+		result.Expected = fmt.Sprintf("%x", check.Expected)
+		result.Actual = fmt.Sprintf("%x", check.Actual)
+	}
 
-				// remove this the verifying file from the files checking list
-				files = append(files[:i], files[i+1:]...)
-				checksums = append(checksums[:i], checksums[i+1:]...)
-				break
-			}
+	var reasons []string
+	if err != nil {
+		result.Status = verifyError
+		reasons = append(reasons, "could not decode: "+err.Error())
+	} else if check.Expected == [16]byte{} {
+		result.Status = verifyError
+		reasons = append(reasons, "no stored audio signature")
+	} else if check.Expected != check.Actual {
+		result.Status = verifyMismatch
+	}
 
+	if check != nil && len(check.BadFrames) > 0 {
+		if result.Status == verifyOK {
+			result.Status = verifyError
 		}
+		reasons = append(reasons, "CRC-16 failed in frame "+verifyFormatFrames(check.BadFrames))
 	}
 
-	if len(files) != 0 {
-		fmt.Printf("\n> ffp: metaflac didn't like:\n\t%s", strings.Join(files, "\n\t"))
-		success = false
-	}
+	result.Reason = strings.Join(reasons, "; ")
+	album.addFile(result)
+	return result.Status == verifyOK
+}
 
-	return
+func verifyFormatFrames(frames []int) string {
+	list := make([]string, len(frames))
+	for i, frame := range frames {
+		list[i] = strconv.Itoa(frame)
+	}
+	return strings.Join(list, ", ")
 }
 
 func verifyReadFFP(line string) (filename, checksum string) {
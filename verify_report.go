@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// verifyWriter outputs the results of verify in a particular format
+type verifyWriter interface {
+	Album(album *verifyAlbum)
+	Finish() error
+}
+
+func newVerifyWriter(format string, out io.Writer) (verifyWriter, error) {
+	switch format {
+	case "text", "":
+		return &verifyTextWriter{out}, nil
+	case "json":
+		return &verifyJSONWriter{out: out}, nil
+	case "junit":
+		return &verifyJUnitWriter{out: out}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q (choose from text, json, junit)", format)
+}
+
+// verifyTextWriter prints each album as soon as it has been verified
+type verifyTextWriter struct {
+	out io.Writer
+}
+
+func (w *verifyTextWriter) Album(album *verifyAlbum) {
+	// Let us know what has been processed
+	fmt.Fprint(w.out, album.Path+"... ")
+
+	for _, message := range album.Errors {
+		fmt.Fprintf(w.out, "\n> %s", message)
+	}
+
+	mismatched := make(map[string][]string)
+	missing := make(map[string][]string)
+	var untracked []string
+
+	for _, file := range album.Files {
+		switch {
+		case file.Algorithm == "audio" && file.Status == verifyMismatch:
+			fmt.Fprintf(w.out, "\n> ffp: decoded audio mismatch for \"%s\" (expected %s, got %s)", file.File, file.Expected, file.Actual)
+			if file.Reason != "" {
+				fmt.Fprintf(w.out, "\n>> %s", file.Reason)
+			}
+		case file.Algorithm == "audio" && file.Status == verifyError:
+			fmt.Fprintf(w.out, "\n> ffp: \"%s\" has a problem (%s)", file.File, file.Reason)
+		case file.Status == verifyMismatch:
+			mismatched[file.File] = append(mismatched[file.File], file.Algorithm)
+		case file.Status == verifyMissing:
+			missing[file.File] = append(missing[file.File], file.Algorithm)
+		case file.Status == verifyUntracked:
+			untracked = append(untracked, file.File)
+		case file.Status == verifyError:
+			fmt.Fprintf(w.out, "\n> %s: read error with %s (%s)", file.Algorithm, file.File, file.Reason)
+		}
+	}
+
+	w.list("mismatched", mismatched)
+	w.list("missing from disk", missing)
+
+	if len(untracked) > 0 {
+		fmt.Fprintf(w.out, "\n> untracked on disk:")
+		for _, filename := range untracked {
+			fmt.Fprintf(w.out, "\n>> %s", filename)
+		}
+	}
+
+	if album.ok() {
+		fmt.Fprintln(w.out, tick)
+		return
+	}
+
+	var summary []string
+	for _, check := range album.Checks {
+		mark := cross
+		if check.Status == verifyOK {
+			mark = tick
+		}
+		summary = append(summary, fmt.Sprintf("%s(%s)", check.Algorithm, mark))
+	}
+
+	fmt.Fprintf(w.out, "\n> done! %s\n\n", strings.Join(summary, " "))
+}
+
+func (w *verifyTextWriter) list(title string, list map[string][]string) {
+	if len(list) == 0 {
+		return
+	}
+
+	filenames := make([]string, 0, len(list))
+	for filename := range list {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	fmt.Fprintf(w.out, "\n> %s:", title)
+	for _, filename := range filenames {
+		fmt.Fprintf(w.out, "\n>> %s (%s)", filename, strings.Join(list[filename], ", "))
+	}
+}
+
+func (w *verifyTextWriter) Finish() error {
+	return nil
+}
+
+// verifyJSONWriter writes every album as a single JSON document at the end
+type verifyJSONWriter struct {
+	out    io.Writer
+	albums []*verifyAlbum
+}
+
+func (w *verifyJSONWriter) Album(album *verifyAlbum) {
+	w.albums = append(w.albums, album)
+}
+
+func (w *verifyJSONWriter) Finish() error {
+	report := struct {
+		Status string         `json:"status"`
+		Albums []*verifyAlbum `json:"albums"`
+	}{
+		Status: verifyOK,
+		Albums: w.albums,
+	}
+
+	if report.Albums == nil {
+		report.Albums = []*verifyAlbum{}
+	}
+	for _, album := range w.albums {
+		if !album.ok() {
+			report.Status = verifyError
+		}
+	}
+
+	encoder := json.NewEncoder(w.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// verifyJUnitWriter writes a JUnit XML report, with
+// one test suite per album and one test case per file check
+type verifyJUnitWriter struct {
+	out    io.Writer
+	albums []*verifyAlbum
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func (w *verifyJUnitWriter) Album(album *verifyAlbum) {
+	w.albums = append(w.albums, album)
+}
+
+func (w *verifyJUnitWriter) Finish() error {
+	var report junitTestSuites
+
+	for _, album := range w.albums {
+		suite := junitTestSuite{Name: album.Name}
+
+		// problems with the album itself get their own test case
+		for _, message := range album.Errors {
+			suite.TestCases = append(suite.TestCases, junitTestCase{
+				Name:      "album",
+				ClassName: album.Name,
+				Error:     &junitMessage{Message: message, Type: verifyError},
+			})
+			suite.Errors++
+		}
+
+		for _, file := range album.Files {
+			testCase := junitTestCase{
+				Name:      file.File,
+				ClassName: album.Name,
+			}
+			if file.Algorithm != "" {
+				testCase.Name = file.Algorithm + ": " + file.File
+			}
+
+			body := ""
+			if file.Expected != "" || file.Actual != "" {
+				body = fmt.Sprintf("expected: %s\nactual: %s", file.Expected, file.Actual)
+			}
+			message := file.Status
+			if file.Reason != "" {
+				message += ": " + file.Reason
+			}
+
+			switch file.Status {
+			case verifyOK:
+			case verifyError:
+				testCase.Error = &junitMessage{Message: message, Type: file.Status, Body: body}
+				suite.Errors++
+			default:
+				testCase.Failure = &junitMessage{Message: message, Type: file.Status, Body: body}
+				suite.Failures++
+			}
+
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+
+		suite.Tests = len(suite.TestCases)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Suites = append(report.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w.out, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w.out)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w.out, "\n")
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	fpath "path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeUnreadable makes a folder in directory whose path is too long to stat,
+// which can't be read even by root, unlike a folder without permissions
+func makeUnreadable(t *testing.T, directory string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err := os.Chdir(directory); err != nil {
+		t.Fatal(err)
+	}
+
+	// folders are made one at a time, as their full path gets too long
+	length := len(directory)
+	for length < 4000 {
+		name := strings.Repeat("d", 200)
+		if err := os.Mkdir(name, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chdir(name); err != nil {
+			t.Fatal(err)
+		}
+		length += len(name) + 1
+	}
+	if err := os.Mkdir(strings.Repeat("x", 200), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyJSONUnreadable(t *testing.T) {
+	directory := t.TempDir()
+	name := fpath.Base(directory)
+
+	notes := []byte("taped from row 12")
+	files := map[string]string{
+		"notes.txt":   string(notes),
+		name + ".md5": checksumFormatLine(md5Sum(notes), "notes.txt"),
+		name + ".ffp": "",
+	}
+	for filename, text := range files {
+		if err := ioutil.WriteFile(fpath.Join(directory, filename), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	makeUnreadable(t, directory)
+
+	// the report is written to stdout, as it is by verify
+	report, err := ioutil.TempFile(t.TempDir(), "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = report
+	defer func() { os.Stdout = stdout }()
+
+	writer, err := newVerifyWriter("json", os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Album(verifyProcessPath(directory, name, false, nil, false))
+	if err := writer.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = stdout
+
+	data, err := ioutil.ReadFile(report.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		Status string         `json:"status"`
+		Albums []*verifyAlbum `json:"albums"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("the report isn't JSON (%v):\n%s", err, data)
+	}
+
+	if result.Status != verifyError || len(result.Albums) != 1 {
+		t.Fatalf("report = %s, expected one album that failed", data)
+	}
+	found := false
+	for _, message := range result.Albums[0].Errors {
+		if strings.HasPrefix(message, "could not read ") && strings.HasSuffix(message, "x (file name too long)") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("album errors = %q, expected the unreadable folder", result.Albums[0].Errors)
+	}
+}
+
+func md5Sum(data []byte) []byte {
+	sum := md5.Sum(data)
+	return sum[:]
+}
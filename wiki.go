@@ -2,11 +2,11 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
-	"os/exec"
 	upath "path"
 	fpath "path/filepath"
 	"regexp"
@@ -15,18 +15,20 @@ import (
 	"text/template"
 
 	"github.com/inhies/go-bytesize" // Do we really need this?
+	"github.com/qaisjp/dmlivewiki/flac"
 	"github.com/qaisjp/dmlivewiki/util"
 	"gopkg.in/urfave/cli.v1"
 )
 
 type WikiTrackData struct {
-	Duration                 string
-	FolderName               string
-	Index                    int
-	HasAlternateLeadVocalist bool
-	Name                     string
-	CD                       int
-	LinePrefix               string
+	Duration    string
+	FolderName  string
+	Index       int
+	Annotations []Annotation
+	Name        string
+	CD          int    // 0 if the track isn't in a CD folder
+	Disc        string // the folder the track is in, like "CD1" or "Bonus"
+	LinePrefix  string
 }
 
 type WikiAlbumData struct {
@@ -38,15 +40,18 @@ type WikiAlbumData struct {
 	Size       string
 	SampleRate string
 	BPS        string
+	Config     *Config
+
+	Annotations []Annotation // used by any track
 }
 
-var bracketRegex *regexp.Regexp
-var wikiRegex *regexp.Regexp
+// Track names in quotes in the notes are linked to their page
+var bracketRegex = regexp.MustCompile(`".*?"`)
 
-func generateWikifiles(c *cli.Context) {
-	fileInfo, filepath := util.CheckFilepathArgument(c)
-	if fileInfo == nil {
-		return
+func generateWikifiles(c *cli.Context) error {
+	fileInfo, filepath, err := util.CheckFilepathArgument(c)
+	if err != nil {
+		return usageError{err}
 	}
 
 	mode := "batch"
@@ -54,29 +59,41 @@ func generateWikifiles(c *cli.Context) {
 		mode = "single"
 	}
 
+	format := c.String("format")
+	if format != "files" && format != "xml-dump" {
+		return newUsageError(c, "unknown format %q, expected files or xml-dump", format)
+	}
+
+	albums, err := findAlbums(c, filepath, fileInfo, os.Stdout)
+	if err != nil {
+		return err
+	}
+
 	fmt.Printf("The following filepath (%s mode) will be processed: %s\n", mode, filepath)
+	printAlbumCount(os.Stdout, albums)
 	util.NotifyDeleteMode(c)
 
 	if !util.ShouldContinue(c) {
-		return
+		return nil
 	}
 
-	wikiRegex = regexp.MustCompile(wikiRegexText)
-	bracketRegex = regexp.MustCompile(`".*?"`)
-
-	wikiTemplate, err := template.New("wiki").Parse(
-		// Stupid windows
-		strings.Replace(wikiTemplate, "\n", "\r\n", -1),
-	)
+	wikiTemplate, err := loadWikiTemplate()
 	if err != nil {
-		fmt.Println("Internal error - wiki template could not be parsed!")
-		fmt.Println(err.Error())
-		os.Exit(1)
+		return usageError{err}
 	}
 
+	out := newOutput(c)
+	if format == "xml-dump" {
+		return generateWikiDump(c, filepath, albums, wikiTemplate, out)
+	}
+
+	batch := newBatchErrors()
 	if mode == "single" {
-		generateWikifile(filepath, fileInfo.Name(), wikiTemplate, c.GlobalBool("delete"), "")
-		return
+		// the album might have been left out by the filter flags
+		for _, album := range albums {
+			batch.add(album.rel, generateWikifile(album, wikiTemplate, out, c.GlobalBool("delete"), "", nil))
+		}
+		return batch.err()
 	}
 
 	// Create the wikifiles folder path
@@ -84,22 +101,24 @@ func generateWikifiles(c *cli.Context) {
 
 	// MkdirAll is used instead of Mkdir because this function
 	// doesn't error if the folder already exists
-	err = os.MkdirAll(wikifiles, os.ModePerm)
+	err = out.mkdirAll(wikifiles)
 	if err != nil {
-		fmt.Println("Internal error creating __wikifiles folder")
-		fmt.Println(err.Error())
-		os.Exit(1)
+		return fmt.Errorf("could not create __wikifiles folder (%s)", err.Error())
 	}
 
-	files, _ := ioutil.ReadDir(filepath)
-	for _, file := range files {
-		if file.IsDir() {
-			name := file.Name()
-			if name != "__wikifiles" {
-				generateWikifile(fpath.Join(filepath, name), name, wikiTemplate, c.GlobalBool("delete"), wikifiles)
-			}
-		}
+	manifest, err := readWikiManifest(wikifiles)
+	if err != nil {
+		return err
+	}
+
+	for _, album := range albums {
+		batch.add(album.rel, generateWikifile(album, wikiTemplate, out, c.GlobalBool("delete"), wikifiles, manifest))
+	}
+
+	if err := manifest.write(wikifiles, out); err != nil {
+		return fmt.Errorf("could not write %s (%s)", wikiManifestFilename, err.Error())
 	}
+	return batch.err()
 }
 
 func wikiGetInfoFromFlac(filepath string, parsedData *WikiAlbumData) bool {
@@ -116,49 +135,17 @@ func wikiGetInfoFromFlac(filepath string, parsedData *WikiAlbumData) bool {
 				return true
 			}
 		} else if fpath.Ext(file.Name()) == ".flac" {
-			data, err := exec.Command(
-				metaflacPath,
-				"--show-sample-rate",
-				"--show-bps", // update numbers below
-				fpath.Join(filepath, file.Name()),
-			).Output()
-
+			meta, err := flac.ReadFile(fpath.Join(filepath, file.Name()))
 			if err != nil {
-				fmt.Println("metaflac returned an invalid response for sample-rate/bps")
-				if data != nil {
-					fmt.Println(data)
-				}
-				panic(err)
-			}
-
-			lines := strings.Split(string(data), "\n")
-			if len(lines) != 1+2 {
-				// Update '2' below and above
-				fmt.Println("expected 2 metaflac lines, got", len(lines)-1)
+				fmt.Println("could not read flac metadata for sample-rate/bps")
+				fmt.Println(err)
 				continue
 			}
 
-			failure := false
-			for i, line := range lines {
-				line := strings.TrimSpace(line)
-
-				if i == 0 {
-					rateFloat, err := strconv.ParseFloat(line, 32)
-					if err != nil {
-						fmt.Print("could not convert sample-rate to float")
-						fmt.Println(err)
-						failure = true
-						break
-					}
-					parsedData.SampleRate = strconv.FormatFloat(rateFloat/1000, 'f', -1, 32) + "KHz"
-				} else if i == 1 {
-					parsedData.BPS = line
-				}
-			}
-
-			if !failure {
-				return true
-			}
+			rate := float64(meta.StreamInfo.SampleRate)
+			parsedData.SampleRate = strconv.FormatFloat(rate/1000, 'f', -1, 32) + "KHz"
+			parsedData.BPS = strconv.Itoa(int(meta.StreamInfo.BitsPerSample))
+			return true
 		}
 	}
 
@@ -166,7 +153,10 @@ func wikiGetInfoFromFlac(filepath string, parsedData *WikiAlbumData) bool {
 	return false
 }
 
-func generateWikifile(filepath string, foldername string, wikiTemplate *template.Template, deleteMode bool, outBasepath string) {
+// generateWikifile writes the page of an album to outBasepath, or into the album if it is empty.
+// The manifest is only used for batch mode, where every page is written to the same folder.
+func generateWikifile(a album, wikiTemplate *template.Template, out *output, deleteMode bool, outBasepath string, manifest *wikiManifest) error {
+	filepath, foldername := a.path, a.name
 	basepath := fpath.Join(filepath, foldername)
 	infofile := basepath + ".txt"
 
@@ -182,173 +172,181 @@ func generateWikifile(filepath string, foldername string, wikiTemplate *template
 		fmt.Printf("Generating from %s... ", infofile)
 	}
 
-	infobytes, err := ioutil.ReadFile(infofile)
+	info, err := readInfoFile(infofile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			fmt.Println("infofile doesn't exist")
-		} else {
-			fmt.Printf("error (%s)\n", err.Error())
+			return errors.New("infofile doesn't exist")
 		}
-		return
+		fmt.Printf("parse failure (%s)\n", err.Error())
+		return err
+	}
+
+	title, err := wikiPageTitle(info)
+	if err != nil {
+		fmt.Println("error unescaping query from url")
+		fmt.Println(err.Error())
+		return err
 	}
 
-	matches := wikiRegex.FindSubmatch(infobytes)
-	if len(matches) != 1+wikiRegex.NumSubexp() {
-		// (entire string itself)+(capture groups)
-		fmt.Printf("parse failure, expected %d capturing groups!\n", 1+wikiRegex.NumSubexp())
-		return
+	filename := wikiFilename(title) + ".wiki"
+	wikifile = fpath.Join(wikifile, filename)
+	fmt.Printf("\n - %s... ", wikifile)
+
+	if manifest != nil {
+		if deleteMode {
+			manifest.remove(filename)
+		} else if err := manifest.claim(filename, title, a.rel); err != nil {
+			fmt.Println("skipping!", err.Error())
+			return err
+		}
 	}
 
+	if deleteMode {
+		message := "success!"
+		if !out.removeFile(wikifile, false) {
+			message = "couldn't delete!"
+		}
+		fmt.Println(message)
+		return nil
+	}
+
+	page, err := renderWikiPage(filepath, foldername, info, wikiTemplate)
+	if err != nil {
+		return err
+	}
+
+	existed, err := out.writeFile(wikifile, page)
+	if err != nil {
+		fmt.Println("could not create file!")
+		fmt.Println(err)
+		return err
+	}
+	if existed {
+		fmt.Print("overwritten... ")
+	}
+
+	fmt.Println("success!")
+	return nil
+}
+
+// wikiPageTitle returns the title of the page from the source URL
+// in the information file, like "1990-05-02 Somewhere/Source 1"
+func wikiPageTitle(info *InfoFile) (string, error) {
+	title, err := url.QueryUnescape(info.PageName())
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(title, "_", " ", -1), nil // make spaces in wikiformat real spaces
+}
+
+// wikiPage is the freshly rendered page of an album
+type wikiPage struct {
+	album album
+	title string
+	text  string
+}
+
+// readWikiPage reads the information file of an album and renders its page
+func readWikiPage(a album, wikiTemplate *template.Template) (page wikiPage, err error) {
+	info, err := readInfoFile(fpath.Join(a.path, a.name+".txt"))
+	if err != nil {
+		return page, err
+	}
+
+	title, err := wikiPageTitle(info)
+	if err != nil {
+		return page, err
+	}
+
+	text, err := renderWikiPage(a.path, a.name, info, wikiTemplate)
+	if err != nil {
+		return page, err
+	}
+	return wikiPage{a, title, string(text)}, nil
+}
+
+// renderWikiPage fills in the wiki template for an album
+func renderWikiPage(filepath string, foldername string, info *InfoFile, wikiTemplate *template.Template) ([]byte, error) {
 	var parsedData WikiAlbumData
 	parsedData.FolderName = foldername
+	parsedData.Config = &config
 
 	size, err := util.GetDirectorySite(filepath)
 	if err != nil {
 		fmt.Println("failed to get directory size")
 		fmt.Println(err)
-		return
+		return nil, err
 	}
 	b := bytesize.New(size)
 	parsedData.Size = b.String()
 
 	if !wikiGetInfoFromFlac(filepath, &parsedData) {
-		return
+		return nil, errors.New("could not read sampling info")
+	}
+
+	for _, item := range strings.Split(info.Lineage, "\n") {
+		parsedData.Lineage += "*" + strings.TrimSpace(item) + "\r\n"
 	}
+	parsedData.Duration = info.TotalTime
 
 	var tracks []WikiTrackData
 	var lastTrack WikiTrackData
 	var currentTrackNumber int
-	var notes string
 
-	for i, field := range matches {
-		if i == 0 {
-			// The first one is just itself
-			continue
-		}
-
-		field := string(bytes.TrimSpace(field))
-
-		switch i {
-		case 1:
-			if field != "" {
-				parsedData.Lineage = "*" + field + "\n"
-			}
-		case 2:
-			lineage := ""
-			for _, item := range strings.Split(field, "\n") {
-				lineage += "*" + strings.TrimSpace(item) + "\r\n"
-			}
-			parsedData.Lineage += lineage
-		case 3:
-			notes = field
-		case 4:
-			str, err := url.QueryUnescape(field)
-			if err != nil {
-				fmt.Println("error unescaping query from url")
-				fmt.Println(err.Error())
-				return
+	for _, track := range info.Tracks {
+		var trackData WikiTrackData
+		trackData.FolderName = foldername
+		trackData.LinePrefix = "#"
+		trackData.Duration = track.Duration
+
+		if track.Prefix != "" {
+			// Numbered prefixes are CDs, anything else
+			// is a folder of its own, like "Bonus"
+			disc := strings.TrimSuffix(track.Prefix, ".")
+			heading := disc
+			if cdNumber, err := strconv.Atoi(disc); err == nil {
+				trackData.CD = cdNumber
+				disc = "CD" + disc
+				heading = fmt.Sprintf("CD%d", cdNumber)
 			}
 
-			str = strings.Replace(str, "_", " ", -1) // make spaces in wikiformat real spaces
-			str = strings.Replace(str, "/", "_", -1) // make slashes fileurl compliant by making it a "_"
-			str = strings.Replace(str, ":", "💩", -1) // makes colons fileurl compliant by making it a pile of poo
-
-			str = strings.Trim(strconv.QuoteToASCII(str), "\"") // make it ascii escaped, and trim "s
-			str = strings.Replace(str, "\\", "^", -1)           // escape "\" with "^" so that the bash script can make it a codepoint again
-
-			wikifile = fpath.Join(wikifile, str+".wiki")
-			fmt.Printf("\n - %s... ", wikifile)
-		case 5:
-			// parse tracks
-			for _, track := range strings.Split(field, "\n") {
-				var trackData WikiTrackData
-				trackData.FolderName = foldername
-				trackData.LinePrefix = "#"
-
-				str := strings.TrimSpace(track)
-				f := strings.Index(str, "[")
-				l := strings.Index(str, "]")
-				trackData.Duration = str[f+1 : l]
-
-				number := str[:f-2]
-				separator := strings.Index(number, ".")
-				if separator != -1 {
-					// This bit only uses the "path" library
-					// because URL's only use forward slash
-					cdStr := number[:separator]
-
-					cdNumber, err := strconv.Atoi(cdStr)
-					if err != nil {
-						panic(err.Error())
-					}
-					trackData.FolderName = upath.Join(foldername, "CD"+cdStr)
-					trackData.CD = cdNumber
-
-					if lastTrack.CD != cdNumber {
-						currentTrackNumber = 0
-						trackData.LinePrefix = fmt.Sprintf("\r\nCD%d:\r\n%s", cdNumber, trackData.LinePrefix)
-					}
-				} else {
-					_ = ("suppressing lint for go-staticcheck SA9003")
-					// Here lies incomplete support for "Bonus." tracks
-					// you need to add support for using filenames instead
-					// of tracknumbers for bonus tracks
-
-					// _, err := strconv.Atoi(number)
-					// if err != nil {
-					// 	trackData.FolderName = number
-					// 	trackData.CD = -1
-
-					// 	if lastTrack.FolderName != number {
-					// 		currentTrackNumber = 0
-					// 	}
-					// }
-					// continue
-				}
-
-				name := strings.TrimSpace(str[l+1:])
-				nameWithoutSuffix := strings.TrimSuffix(name, " (*)")
-				trackData.HasAlternateLeadVocalist = name != nameWithoutSuffix
-				trackData.Name = nameWithoutSuffix
-
-				currentTrackNumber++
-				trackData.Index = currentTrackNumber
-
-				lastTrack = trackData
-				tracks = append(tracks, trackData)
+			// This bit only uses the "path" library
+			// because URL's only use forward slash
+			trackData.FolderName = upath.Join(foldername, url.PathEscape(disc))
+			trackData.Disc = disc
+
+			if lastTrack.Disc != disc {
+				currentTrackNumber = 0
+				trackData.LinePrefix = fmt.Sprintf("\r\n%s:\r\n%s", heading, trackData.LinePrefix)
 			}
-		case 6:
-			parsedData.Duration = field
+		} else if lastTrack.Disc != "" {
+			fmt.Printf("tracks without a CD or folder prefix have to come first (line %d)\n", track.Line)
+			return nil, &InfoFileError{track.Line, "tracks without a CD or folder prefix have to come first"}
 		}
-	}
-	parsedData.Tracks = tracks
-	parsedData.Notes = bracketRegex.ReplaceAllStringFunc(notes, wikiReplace(tracks))
 
-	success := util.RemoveFile(wikifile, false)
-	if deleteMode {
-		message := "success!"
-		if !success {
-			message = "couldn't delete!"
-		}
-		fmt.Println(message)
-		return
-	} else if success {
-		fmt.Print("overwritten... ")
-	}
+		trackData.Annotations = track.Annotations
+		parsedData.Annotations = usedAnnotations(parsedData.Annotations, track.Annotations)
+		trackData.Name = track.Title
 
-	wikiout := util.CreateFile(wikifile)
-	defer wikiout.Close()
+		currentTrackNumber++
+		trackData.Index = currentTrackNumber
 
-	if wikiout != nil {
-		err = wikiTemplate.Execute(wikiout, parsedData)
-		if err != nil {
-			fmt.Println("could not insert data into template!")
-			fmt.Println(err)
-			return
-		}
+		lastTrack = trackData
+		tracks = append(tracks, trackData)
+	}
+	parsedData.Tracks = tracks
+	notes := strings.Replace(info.Notes, "\n", "\r\n", -1) // Stupid windows
+	parsedData.Notes = bracketRegex.ReplaceAllStringFunc(notes, wikiReplace(tracks))
 
-		fmt.Println("success!")
+	var wikiout bytes.Buffer
+	err = wikiTemplate.Execute(&wikiout, parsedData)
+	if err != nil {
+		fmt.Println("could not insert data into template!")
+		fmt.Println(err)
+		return nil, err
 	}
+	return wikiout.Bytes(), nil
 }
 
 func wikiReplace(tracks []WikiTrackData) func(string) string {
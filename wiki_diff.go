@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/qaisjp/dmlivewiki/mediawiki"
+	"github.com/qaisjp/dmlivewiki/util"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// diffWikifiles shows how the page of each album on the wiki differs from what would be published
+func diffWikifiles(c *cli.Context) error {
+	fileInfo, filepath, err := util.CheckFilepathArgument(c)
+	if err != nil {
+		return usageError{err}
+	}
+
+	if c.GlobalBool("delete") {
+		return usageError{errors.New(`"delete" doesn't apply to this commmand`)}
+	}
+
+	dump := c.String("dump")
+	if dump != "" {
+		_, dump, err = util.GetFileOfType(dump, false, "dump")
+		if err != nil {
+			return usageError{err}
+		}
+	}
+
+	mode := "batch"
+	if c.GlobalBool("single") {
+		mode = "single"
+	}
+
+	albums, err := findAlbums(c, filepath, fileInfo, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	source := config.APIURL
+	if dump != "" {
+		source = dump
+	}
+	fmt.Printf("The following filepath (%s mode) will be compared with %s: %s\n", mode, source, filepath)
+	printAlbumCount(os.Stdout, albums)
+
+	if !util.ShouldContinue(c) {
+		return nil
+	}
+
+	wikiTemplate, err := loadWikiTemplate()
+	if err != nil {
+		return usageError{err}
+	}
+
+	batch := newBatchErrors()
+	var pages []wikiPage
+	var titles []string
+	for _, album := range albums {
+		page, err := readWikiPage(album, wikiTemplate)
+		if err != nil {
+			fmt.Printf("Could not render the page of %s (%s)\n", album.rel, err.Error())
+			batch.add(album.rel, err)
+			continue
+		}
+		pages = append(pages, page)
+		titles = append(titles, page.title)
+	}
+
+	var revisions map[string]mediawiki.Revision
+	if dump != "" {
+		revisions, err = wikiDiffReadDump(dump)
+	} else {
+		revisions, err = wikiDiffFetch(titles)
+	}
+	if err != nil {
+		return err
+	}
+
+	published, err := loadPublishedRevisions()
+	if err != nil {
+		fmt.Println("Can't tell which pages were edited since they were published:", err.Error())
+	}
+
+	var created, changed, edited int
+	for _, page := range pages {
+		revision, ok := revisions[page.title]
+		if !ok || revision.Missing {
+			fmt.Printf("\n+ %s would be a new page\n", page.title)
+			created++
+			batch.add(page.album.rel, nil)
+			continue
+		}
+
+		current := wikiNormalize(revision.Text)
+		rendered := wikiNormalize(page.text)
+		if current == rendered {
+			fmt.Printf("\n= %s is up to date\n", page.title)
+			batch.add(page.album.rel, nil)
+			continue
+		}
+
+		changed++
+		fmt.Printf("\n~ %s would be changed\n", page.title)
+		if reason := wikiEditedReason(page.title, revision, published); reason != "" {
+			edited++
+			fmt.Printf("!! %s, publishing would overwrite their changes\n", reason)
+		}
+		fmt.Print(util.UnifiedDiff("wiki/"+page.title, "rendered/"+page.title, current+"\n", rendered+"\n"))
+		batch.add(page.album.rel, nil)
+	}
+
+	fmt.Printf("\n%d new page(s), %d changed page(s), %d edited on the wiki since they were published\n", created, changed, edited)
+	if err := batch.err(); err != nil {
+		return err
+	}
+	if edited > 0 {
+		return wikiEditedError{edited}
+	}
+	return nil
+}
+
+// wikiEditedError is returned when publishing would overwrite edits made on the wiki,
+// so that scripts can stop before running publish
+type wikiEditedError struct {
+	pages int
+}
+
+func (e wikiEditedError) Error() string {
+	return fmt.Sprintf("%d page(s) were edited on the wiki since they were published", e.pages)
+}
+
+func (e wikiEditedError) ExitCode() int {
+	return exitEdited
+}
+
+func wikiDiffReadDump(filename string) (map[string]mediawiki.Revision, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	revisions, err := mediawiki.ReadDump(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the dump %s (%s)", filename, err.Error())
+	}
+	return revisions, nil
+}
+
+// wikiDiffFetch gets the pages from the wiki, logging in first if there is
+// a bot password, as some wikis can't be read without logging in
+func wikiDiffFetch(titles []string) (map[string]mediawiki.Revision, error) {
+	client, err := mediawiki.NewClient(config.APIURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if password := os.Getenv(botPasswordVariable); password != "" && config.BotUsername != "" {
+		if err := client.Login(config.BotUsername, password); err != nil {
+			return nil, err
+		}
+	}
+
+	list, err := client.Pages(titles)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch the pages from %s (%s)", config.APIURL, err.Error())
+	}
+
+	revisions := make(map[string]mediawiki.Revision)
+	for i, revision := range list {
+		revisions[titles[i]] = revision
+	}
+	return revisions, nil
+}
+
+// The wiki doesn't keep windows line endings or whitespace at the end of a page
+func wikiNormalize(text string) string {
+	return strings.TrimRight(strings.Replace(text, "\r\n", "\n", -1), " \t\n")
+}
+
+// wikiEditedReason explains how a page was changed by someone other than publish,
+// or returns "" if it wasn't. Without a record of what was published, the page
+// counts as edited if it was last edited by anyone but the bot account.
+func wikiEditedReason(title string, revision mediawiki.Revision, published *publishedRevisions) string {
+	by := fmt.Sprintf("by %s on %s", revision.User, revision.Timestamp.Format("2006-01-02 15:04"))
+
+	if published != nil {
+		if id, ok := published.get(title); ok {
+			if id == revision.ID {
+				return ""
+			}
+			return fmt.Sprintf("edited on the wiki %s since revision %d was published", by, id)
+		}
+	}
+
+	// bot passwords are "User@name", and edits are made as "User"
+	account := strings.SplitN(config.BotUsername, "@", 2)[0]
+	if account == "" || strings.EqualFold(revision.User, account) {
+		return ""
+	}
+	return "last edited on the wiki " + by + ", not by publish"
+}
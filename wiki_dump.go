@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	fpath "path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/qaisjp/dmlivewiki/mediawiki"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const wikiDumpFilename = "dump.xml"
+
+// generateWikiDump writes the page of every album into one XML dump, in __wikifiles
+// for batch mode and in the album for single mode, so a whole tour can be imported at once
+func generateWikiDump(c *cli.Context, filepath string, albums []album, wikiTemplate *template.Template, out *output) error {
+	directory := filepath
+	if !c.GlobalBool("single") {
+		directory = fpath.Join(filepath, "__wikifiles")
+	}
+	dumpfile := fpath.Join(directory, wikiDumpFilename)
+
+	if c.GlobalBool("delete") {
+		out.removeFile(dumpfile, true)
+		return nil
+	}
+
+	if err := out.mkdirAll(directory); err != nil {
+		return fmt.Errorf("could not create %s folder (%s)", directory, err.Error())
+	}
+
+	contributor := c.String("contributor")
+	if contributor == "" {
+		// bot passwords are "User@name", and edits are made as "User"
+		contributor = strings.SplitN(config.BotUsername, "@", 2)[0]
+	}
+	if contributor == "" {
+		contributor = "dmlivewiki"
+	}
+
+	now := time.Now()
+	batch := newBatchErrors()
+	var pages []mediawiki.DumpPage
+	titles := make(map[string]string) // to the album, so that pages aren't in the dump twice
+
+	for _, album := range albums {
+		fmt.Printf("Adding %s to the dump... ", album.rel)
+
+		page, err := readWikiPage(album, wikiTemplate)
+		if err != nil {
+			fmt.Printf("failed (%s)\n", err.Error())
+			batch.add(album.rel, err)
+			continue
+		}
+
+		if other, ok := titles[page.title]; ok {
+			fmt.Printf("skipping! %s is also the page of %s\n", page.title, other)
+			batch.add(album.rel, fmt.Errorf("%s is also the page of %s", page.title, other))
+			continue
+		}
+		titles[page.title] = album.rel
+
+		// the wiki doesn't keep windows line endings
+		text := strings.Replace(page.text, "\r\n", "\n", -1)
+		pages = append(pages, mediawiki.DumpPage{
+			Title:     page.title,
+			Revisions: []mediawiki.DumpRevision{mediawiki.NewDumpRevision(text, now, contributor, c.String("summary"))},
+		})
+
+		fmt.Println(page.title)
+		batch.add(album.rel, nil)
+	}
+
+	if len(pages) > 0 {
+		var data bytes.Buffer
+		if err := mediawiki.WriteDump(&data, pages); err != nil {
+			return err
+		}
+
+		fmt.Printf("Writing %d page(s) to %s...\n", len(pages), dumpfile)
+		if _, err := out.writeFile(dumpfile, data.Bytes()); err != nil {
+			return fmt.Errorf("could not write %s (%s)", dumpfile, err.Error())
+		}
+	}
+	return batch.err()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	fpath "path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// wikiManifest is __wikifiles/manifest.json, which has the exact
+// page title of every .wiki file, so that importers don't have to
+// work it out from the filename
+type wikiManifest struct {
+	Pages []wikiManifestPage `json:"pages"`
+
+	claimed map[string]string // files written this run, to the album they are from
+}
+
+type wikiManifestPage struct {
+	File  string `json:"file"`
+	Title string `json:"title"`
+	Album string `json:"album"` // the path of the album from the directory wiki was run on
+}
+
+const wikiManifestFilename = "manifest.json"
+
+// readWikiManifest reads the manifest in a folder, which is empty if there isn't one yet
+func readWikiManifest(directory string) (*wikiManifest, error) {
+	manifest := &wikiManifest{claimed: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(fpath.Join(directory, wikiManifestFilename))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("could not read %s (%s)", wikiManifestFilename, err.Error())
+	}
+	return manifest, nil
+}
+
+// claim records the page written to a file, failing if
+// another album has already written to it in this run
+func (m *wikiManifest) claim(file string, title string, album string) error {
+	if other, ok := m.claimed[file]; ok && other != album {
+		return fmt.Errorf("%s is also the page of %s", title, other)
+	}
+	m.claimed[file] = album
+
+	m.remove(file)
+	m.Pages = append(m.Pages, wikiManifestPage{file, title, album})
+	return nil
+}
+
+func (m *wikiManifest) remove(file string) {
+	pages := m.Pages[:0]
+	for _, page := range m.Pages {
+		if page.File != file {
+			pages = append(pages, page)
+		}
+	}
+	m.Pages = pages
+}
+
+func (m *wikiManifest) title(file string) (string, bool) {
+	for _, page := range m.Pages {
+		if page.File == file {
+			return page.Title, true
+		}
+	}
+	return "", false
+}
+
+// write saves the manifest, leaving out pages whose file has been deleted
+func (m *wikiManifest) write(directory string, out *output) error {
+	pages := m.Pages[:0]
+	for _, page := range m.Pages {
+		if _, err := os.Stat(fpath.Join(directory, page.File)); err == nil || m.claimed[page.File] != "" {
+			pages = append(pages, page)
+		}
+	}
+	m.Pages = pages
+
+	sort.Slice(m.Pages, func(i, j int) bool {
+		return m.Pages[i].File < m.Pages[j].File
+	})
+
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = out.writeFile(fpath.Join(directory, wikiManifestFilename), append(data, '\n'))
+	return err
+}
+
+// wikiFilename turns a page title into a filename that works everywhere, by percent
+// encoding anything that isn't a plain letter, number, space or punctuation that
+// is safe in filenames. url.PathUnescape turns it back into the title.
+func wikiFilename(title string) string {
+	var b strings.Builder
+	for i := 0; i < len(title); i++ {
+		c := title[i]
+		if wikiFilenameSafe(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func wikiFilenameSafe(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte(" !#$&'()+,-.;=@[]^_`{}~", c) != -1
+}
+
+// decodeWikiTitles prints the page title of each .wiki file given, from the
+// manifest next to it or, if it isn't in one, from the filename itself
+func decodeWikiTitles(c *cli.Context) error {
+	if len(c.Args()) == 0 {
+		return newUsageError(c, "expected at least one .wiki file")
+	}
+
+	manifests := make(map[string]*wikiManifest)
+	for _, filename := range c.Args() {
+		directory, file := fpath.Split(filename)
+
+		manifest, ok := manifests[directory]
+		if !ok {
+			var err error
+			manifest, err = readWikiManifest(directory)
+			if err != nil {
+				return err
+			}
+			manifests[directory] = manifest
+		}
+
+		if title, ok := manifest.title(file); ok {
+			fmt.Println(title)
+			continue
+		}
+
+		title, err := url.PathUnescape(strings.TrimSuffix(file, ".wiki"))
+		if err != nil {
+			return fmt.Errorf("could not decode %s (%s)", filename, err.Error())
+		}
+		fmt.Println(title)
+	}
+	return nil
+}